@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	totalAbsorbedConns uint64
+	totalAbsorbedBytes uint64
+)
+
+// serveAbsorb accepts connections from ln and reads from them without ever
+// writing a byte back. It returns nil once ln is closed.
+func serveAbsorb(ln net.Listener, maxBytes int64, timeout time.Duration) error {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		atomic.AddUint64(&totalAbsorbedConns, 1)
+		go absorb(c, maxBytes, timeout)
+	}
+}
+
+// absorb reads and discards data from c until the peer closes it, maxBytes
+// have been read or timeout has passed; zero disables either limit.
+func absorb(c net.Conn, maxBytes int64, timeout time.Duration) {
+	defer c.Close()
+	if timeout > 0 {
+		c.SetReadDeadline(time.Now().Add(timeout))
+	}
+	buf := make([]byte, 32*1024)
+	var total int64
+	for {
+		n, err := c.Read(buf)
+		total += int64(n)
+		atomic.AddUint64(&totalAbsorbedBytes, uint64(n))
+		if err != nil || maxBytes > 0 && total >= maxBytes {
+			return
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+var totalAccessLogErrors uint64
+
+// accessLogEntry is one JSON line of the access log.
+type accessLogEntry struct {
+	Time          string `json:"time"`
+	Remote        string `json:"remote"`
+	Method        string `json:"method"`
+	URI           string `json:"uri"`
+	Proto         string `json:"proto"`
+	Status        int    `json:"status"`
+	RequestBytes  int    `json:"request_bytes"`
+	DurationMicro int64  `json:"duration_us"`
+	ConnLabel     string `json:"conn_label,omitempty"`
+	Body          string `json:"body,omitempty"`
+	BodyTruncated bool   `json:"body_truncated,omitempty"`
+}
+
+// accessLogger writes one JSON line per request. Writes are buffered and
+// flushed every second and on close.
+type accessLogger struct {
+	mu  sync.Mutex
+	w   *bufio.Writer
+	out io.WriteCloser
+
+	logBody bool
+	maxBody int
+}
+
+// newAccessLogger opens path for appending, or uses stdout for "-".
+func newAccessLogger(path string, logBody bool, maxBody int) (*accessLogger, error) {
+	var out io.WriteCloser = os.Stdout
+	if path != "-" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		out = f
+	}
+	l := &accessLogger{w: bufio.NewWriter(out), out: out, logBody: logBody, maxBody: maxBody}
+	go func() {
+		for range time.Tick(time.Second) {
+			l.mu.Lock()
+			l.w.Flush()
+			l.mu.Unlock()
+		}
+	}()
+	return l, nil
+}
+
+// newAccessLogEntry summarizes the request in ctx after it was handled.
+func newAccessLogEntry(ctx *fasthttp.RequestCtx, connLabel []byte) accessLogEntry {
+	return accessLogEntry{
+		Time:          ctx.Time().UTC().Format(time.RFC3339Nano),
+		Remote:        ctx.RemoteAddr().String(),
+		Method:        string(ctx.Method()),
+		URI:           string(ctx.RequestURI()),
+		Proto:         string(ctx.Request.Header.Protocol()),
+		Status:        ctx.Response.StatusCode(),
+		RequestBytes:  len(ctx.Request.Header.RawHeaders()) + len(ctx.Request.Body()),
+		DurationMicro: time.Since(ctx.Time()).Microseconds(),
+		ConnLabel:     string(connLabel),
+	}
+}
+
+func (l *accessLogger) log(ctx *fasthttp.RequestCtx, connLabel []byte) {
+	e := newAccessLogEntry(ctx, connLabel)
+	if l.logBody {
+		body := ctx.Request.Body()
+		if len(body) > l.maxBody {
+			body = body[:l.maxBody]
+			e.BodyTruncated = true
+		}
+		e.Body = string(body)
+	}
+	b, err := json.Marshal(&e)
+	if err != nil {
+		atomic.AddUint64(&totalAccessLogErrors, 1)
+		return
+	}
+	l.mu.Lock()
+	l.w.Write(b)
+	_, err = l.w.Write([]byte{'\n'})
+	l.mu.Unlock()
+	if err != nil {
+		atomic.AddUint64(&totalAccessLogErrors, 1)
+	}
+}
+
+// close flushes pending entries and closes the log file.
+func (l *accessLogger) close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	err := l.w.Flush()
+	if l.out != os.Stdout {
+		if cerr := l.out.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
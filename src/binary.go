@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sync/atomic"
+
+	"github.com/valyala/fasthttp"
+)
+
+const binaryPatternSize = 64 << 10
+
+var totalBinaryBytes uint64
+
+// buildBinaryPattern returns a binaryPatternSize buffer filled with the
+// named pattern.
+func buildBinaryPattern(pattern string) ([]byte, error) {
+	b := make([]byte, binaryPatternSize)
+	switch pattern {
+	case "zeros":
+	case "ones":
+		for i := range b {
+			b[i] = 0xff
+		}
+	case "alternating":
+		for i := range b {
+			if i%2 == 1 {
+				b[i] = 0xff
+			}
+		}
+	case "random":
+		for i := range b {
+			b[i] = byte(rand.Uint32())
+		}
+	case "counter":
+		for i := range b {
+			b[i] = byte(i)
+		}
+	default:
+		return nil, fmt.Errorf("unknown pattern (want zeros, ones, alternating, random or counter)")
+	}
+	return b, nil
+}
+
+func newBinaryHandler(body []byte) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		ctx.SetStatusCode(fasthttp.StatusOK)
+		ctx.SetContentType("application/octet-stream")
+		ctx.SetBody(body)
+		atomic.AddUint64(&totalBinaryBytes, uint64(len(body)))
+	}
+}
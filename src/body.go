@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"time"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// encodeBody converts a UTF-8 response body to the given -body-encoding and
+// returns it together with the matching Content-Type.
+func encodeBody(body []byte, encoding string) ([]byte, string, error) {
+	switch encoding {
+	case "utf-8":
+		return body, "text/plain; charset=utf-8", nil
+	case "latin-1":
+		b, err := charmap.ISO8859_1.NewEncoder().Bytes(body)
+		if err != nil {
+			return nil, "", err
+		}
+		return b, "text/plain; charset=iso-8859-1", nil
+	case "base64":
+		b := make([]byte, base64.StdEncoding.EncodedLen(len(body)))
+		base64.StdEncoding.Encode(b, body)
+		return b, "text/plain; charset=us-ascii", nil
+	default:
+		return nil, "", fmt.Errorf("unknown encoding (want utf-8, latin-1 or base64)")
+	}
+}
+
+// xmlEnvelope wraps body, XML-escaped, in a minimal XML response document.
+func xmlEnvelope(body []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0"?><response><status>OK</status><body>`)
+	xml.EscapeText(&b, body)
+	b.WriteString(`</body></response>`)
+	return b.Bytes()
+}
+
+// jsonEnvelopePrefix marshals the static fields of the JSON response and
+// returns the document without its closing brace, so that appendJSONTime
+// only has to add the per-request timestamp.
+func jsonEnvelopePrefix(body string) ([]byte, error) {
+	b, err := json.Marshal(struct {
+		Status string `json:"status"`
+		Body   string `json:"body"`
+		Server string `json:"server"`
+	}{"ok", body, "fast-ok-server"})
+	if err != nil {
+		return nil, err
+	}
+	return b[:len(b)-1], nil
+}
+
+// appendJSONTime completes a document started by jsonEnvelopePrefix.
+func appendJSONTime(dst []byte, t time.Time) []byte {
+	dst = append(dst, `,"time":`...)
+	dst = strconv.AppendInt(dst, t.UnixMilli(), 10)
+	return append(dst, '}')
+}
+
+// appendBodyCounter appends the -response-body-counter suffix for request n.
+func appendBodyCounter(dst []byte, n uint64) []byte {
+	return strconv.AppendUint(append(dst, '-'), n, 10)
+}
+
+// contentMD5 returns the base64 encoded MD5 of body for a Content-MD5 header.
+func contentMD5(body []byte) string {
+	sum := md5.Sum(body)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/hex"
+	"hash"
+	"hash/crc32"
+	"io"
+	"sync/atomic"
+
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	totalBodyWriteErrors    uint64
+	totalTrailerWriteErrors uint64
+)
+
+const crcTrailer = "X-Body-CRC32"
+
+// chunkedBody is a response body stream of unknown length, which makes
+// fasthttp send it with chunked transfer encoding. With crc set, the CRC32
+// of the streamed bytes is stored in the X-Body-CRC32 trailer once the body
+// is exhausted.
+type chunkedBody struct {
+	body   []byte
+	header *fasthttp.ResponseHeader
+	crc    hash.Hash32
+	eof    bool
+}
+
+func newChunkedBody(body []byte, header *fasthttp.ResponseHeader, withCRC bool) *chunkedBody {
+	b := &chunkedBody{body: body, header: header}
+	if withCRC {
+		b.crc = crc32.NewIEEE()
+		header.SetTrailer(crcTrailer)
+	}
+	return b
+}
+
+func (b *chunkedBody) Read(p []byte) (int, error) {
+	if len(b.body) == 0 {
+		if !b.eof && b.crc != nil {
+			b.header.Set(crcTrailer, hex.EncodeToString(b.crc.Sum(nil)))
+		}
+		b.eof = true
+		return 0, io.EOF
+	}
+	n := copy(p, b.body)
+	b.body = b.body[n:]
+	if b.crc != nil {
+		b.crc.Write(p[:n])
+	}
+	return n, nil
+}
+
+// CloseWithError is called by fasthttp with the error of writing the
+// response. Errors after the body was fully read happened while writing the
+// final chunk or the trailers.
+func (b *chunkedBody) CloseWithError(err error) error {
+	if err != nil {
+		if b.eof {
+			atomic.AddUint64(&totalTrailerWriteErrors, 1)
+		} else {
+			atomic.AddUint64(&totalBodyWriteErrors, 1)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// reuseWatchTimeout is how long a connection is watched for another request
+// after its Connection: close response.
+const reuseWatchTimeout = time.Second
+
+var (
+	totalConnCloseResponses  uint64
+	totalKeepAliveViolations uint64
+)
+
+// newConnectionCloseHandler sends the response built by respond with
+// Connection: close and then shuts down the write side of the connection.
+// A client that sends another request on it anyway within
+// reuseWatchTimeout counts as a keep-alive violation.
+func newConnectionCloseHandler(respond fasthttp.RequestHandler, serverName string) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		respond(ctx)
+		ctx.Response.Header.SetConnectionClose()
+		resp := serializeResponse(ctx, serverName, len(ctx.Response.Body()), ctx.Response.Body())
+
+		// the hijack handler only sees fasthttp's wrapper around the
+		// connection, so look up the TCP connection now
+		cc := countingConnOf(ctx.Conn())
+		writeRawResponse(ctx, resp, func(c net.Conn) {
+			atomic.AddUint64(&totalConnCloseResponses, 1)
+			if cc == nil {
+				return
+			}
+			tc, ok := cc.Conn.(*net.TCPConn)
+			if !ok || tc.CloseWrite() != nil {
+				return
+			}
+			// reads through the wrapper also see anything fasthttp had
+			// already buffered, such as a pipelined request
+			c.SetReadDeadline(time.Now().Add(reuseWatchTimeout))
+			var buf [1]byte
+			if n, _ := c.Read(buf[:]); n > 0 {
+				atomic.AddUint64(&totalKeepAliveViolations, 1)
+			}
+		})
+	}
+}
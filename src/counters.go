@@ -0,0 +1,80 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// keyedCounters is a set of per-key counters safe for concurrent use. Once
+// max distinct keys have been seen, new keys are dropped so a client sending
+// random values can't grow the map without bound.
+type keyedCounters struct {
+	m   sync.Map // string -> *uint64
+	n   int64
+	max int64
+}
+
+func newKeyedCounters(max int) *keyedCounters {
+	return &keyedCounters{max: int64(max)}
+}
+
+// inc increments the counter for key. The byte slice is only copied when the
+// key is seen for the first time.
+func (kc *keyedCounters) inc(key []byte) {
+	kc.add(key, 1)
+}
+
+// add adds delta to the counter for key, like inc.
+func (kc *keyedCounters) add(key []byte, delta uint64) {
+	v, ok := kc.m.Load(string(key))
+	if !ok {
+		if atomic.LoadInt64(&kc.n) >= kc.max {
+			return
+		}
+		actual, loaded := kc.m.LoadOrStore(string(key), new(uint64))
+		if !loaded {
+			atomic.AddInt64(&kc.n, 1)
+		}
+		v = actual
+	}
+	atomic.AddUint64(v.(*uint64), delta)
+}
+
+// get returns the count for key, 0 if it hasn't been seen.
+func (kc *keyedCounters) get(key string) uint64 {
+	if v, ok := kc.m.Load(key); ok {
+		return atomic.LoadUint64(v.(*uint64))
+	}
+	return 0
+}
+
+type keyedCount struct {
+	key   string
+	count uint64
+}
+
+// top returns the n keys with the highest counts.
+func (kc *keyedCounters) top(n int) []keyedCount {
+	var items []keyedCount
+	kc.m.Range(func(k, v any) bool {
+		items = append(items, keyedCount{key: k.(string), count: atomic.LoadUint64(v.(*uint64))})
+		return true
+	})
+	sort.Slice(items, func(i, j int) bool { return items[i].count > items[j].count })
+	if len(items) > n {
+		items = items[:n]
+	}
+	return items
+}
+
+// formatKeyedCounts renders counts as space separated key=count pairs.
+func formatKeyedCounts(items []keyedCount) string {
+	parts := make([]string, 0, len(items))
+	for _, it := range items {
+		parts = append(parts, it.key+"="+strconv.FormatUint(it.count, 10))
+	}
+	return strings.Join(parts, " ")
+}
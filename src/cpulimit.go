@@ -0,0 +1,68 @@
+package main
+
+import (
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// cpuSampleInterval is how often the process CPU time is checked against
+// the -cpu-limit budget.
+const cpuSampleInterval = 100 * time.Millisecond
+
+var (
+	totalCPUThrottled     uint64
+	totalCPUThrottleNanos uint64
+)
+
+// cpuLimiter keeps the process to limit CPUs on average with a token bucket
+// of CPU time, refilled at limit seconds per second. Once the bucket runs
+// dry, requests are held until enough idle time has passed to pay back the
+// overage.
+type cpuLimiter struct {
+	limit      float64
+	pauseUntil int64 // unix nanos
+}
+
+func newCPULimiter(limit float64) *cpuLimiter {
+	l := &cpuLimiter{limit: limit}
+	go l.run()
+	return l
+}
+
+// processCPUTime returns the user plus system CPU time used by the process.
+func processCPUTime() time.Duration {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
+
+func (l *cpuLimiter) run() {
+	// allow bursts of up to one interval's budget
+	burst := time.Duration(l.limit * float64(cpuSampleInterval))
+	tokens := burst
+	lastCPU, lastWall := processCPUTime(), time.Now()
+	for now := range time.Tick(cpuSampleInterval) {
+		cpu := processCPUTime()
+		tokens += time.Duration(l.limit*float64(now.Sub(lastWall))) - (cpu - lastCPU)
+		tokens = min(tokens, burst)
+		lastCPU, lastWall = cpu, now
+		if tokens < 0 {
+			pause := time.Duration(float64(-tokens) / l.limit)
+			atomic.StoreInt64(&l.pauseUntil, now.Add(pause).UnixNano())
+		}
+	}
+}
+
+// wait blocks while the limiter is paying back CPU overage.
+func (l *cpuLimiter) wait() {
+	d := time.Duration(atomic.LoadInt64(&l.pauseUntil) - time.Now().UnixNano())
+	if d <= 0 {
+		return
+	}
+	atomic.AddUint64(&totalCPUThrottled, 1)
+	atomic.AddUint64(&totalCPUThrottleNanos, uint64(d))
+	time.Sleep(d)
+}
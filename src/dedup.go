@@ -0,0 +1,75 @@
+package main
+
+import (
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// dedupBodyPrefix is how much of the request body goes into a fingerprint.
+const dedupBodyPrefix = 64
+
+var totalDuplicates uint64
+
+type dedupEntry struct {
+	at     time.Time
+	status int
+}
+
+// requestDeduper remembers request fingerprints for window. A repeat within
+// the window gets the status code of the first response without reaching
+// the wrapped handler.
+type requestDeduper struct {
+	window time.Duration
+	seen   sync.Map // uint64 fingerprint -> dedupEntry
+}
+
+func newRequestDeduper(window time.Duration) *requestDeduper {
+	d := &requestDeduper{window: window}
+	go d.evict()
+	return d
+}
+
+func requestFingerprint(ctx *fasthttp.RequestCtx) uint64 {
+	h := fnv.New64a()
+	h.Write(ctx.Method())
+	h.Write([]byte{' '})
+	h.Write(ctx.Path())
+	h.Write([]byte{' '})
+	body := ctx.Request.Body()
+	if len(body) > dedupBodyPrefix {
+		body = body[:dedupBodyPrefix]
+	}
+	h.Write(body)
+	return h.Sum64()
+}
+
+func (d *requestDeduper) wrap(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		fp := requestFingerprint(ctx)
+		if v, ok := d.seen.Load(fp); ok {
+			if e := v.(dedupEntry); ctx.Time().Sub(e.at) < d.window {
+				atomic.AddUint64(&totalDuplicates, 1)
+				ctx.SetStatusCode(e.status)
+				return
+			}
+		}
+		next(ctx)
+		d.seen.Store(fp, dedupEntry{at: ctx.Time(), status: ctx.Response.StatusCode()})
+	}
+}
+
+// evict drops fingerprints older than the window, checking once per window.
+func (d *requestDeduper) evict() {
+	for now := range time.Tick(d.window) {
+		d.seen.Range(func(k, v any) bool {
+			if now.Sub(v.(dedupEntry).at) >= d.window {
+				d.seen.Delete(k)
+			}
+			return true
+		})
+	}
+}
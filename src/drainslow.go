@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	totalDrainedBodies uint64
+	totalDrainedBytes  uint64
+	totalDrainNanos    uint64
+	totalDrainErrors   uint64
+	totalDrainTooLarge uint64
+)
+
+// drainSlowly reads the streamed request body at rate bytes per second and
+// then sets it as the request body, so everything after sees it as if
+// fasthttp had read it. The reads stop once the socket buffers fill up,
+// which pushes back on the client.
+//
+// -read-timeout would otherwise cover the whole slow read, so it's applied
+// to each read instead.
+//
+// Bodies longer than maxBytes aren't kept: drainSlowly stops reading and
+// reports false, and the request should be refused.
+func drainSlowly(ctx *fasthttp.RequestCtx, rate, maxBytes int, readTimeout time.Duration) bool {
+	r := ctx.RequestBodyStream()
+	if r == nil {
+		return true
+	}
+	// about ten reads per second, so the pacing stays smooth
+	buf := make([]byte, min(max(rate/10, 1), 64<<10))
+	var body []byte
+	start := time.Now()
+	for {
+		if readTimeout > 0 {
+			ctx.Conn().SetReadDeadline(time.Now().Add(readTimeout))
+		}
+		n, err := r.Read(buf)
+		if len(body)+n > maxBytes {
+			atomic.AddUint64(&totalDrainTooLarge, 1)
+			return false
+		}
+		body = append(body, buf[:n]...)
+		if n > 0 {
+			due := start.Add(time.Duration(float64(len(body)) / float64(rate) * float64(time.Second)))
+			time.Sleep(time.Until(due))
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				atomic.AddUint64(&totalDrainErrors, 1)
+			}
+			break
+		}
+	}
+	atomic.AddUint64(&totalDrainedBodies, 1)
+	atomic.AddUint64(&totalDrainedBytes, uint64(len(body)))
+	atomic.AddUint64(&totalDrainNanos, uint64(time.Since(start)))
+	ctx.Request.SetBody(body)
+	return true
+}
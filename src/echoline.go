@@ -0,0 +1,30 @@
+package main
+
+import (
+	"sync/atomic"
+
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	totalEchoLineResponses uint64
+	totalEchoLineBytes     uint64
+)
+
+// respondEchoLine answers with the request line as received, e.g.
+// "GET /foo?bar=1 HTTP/1.1", so clients can check how they encoded the URI.
+func respondEchoLine(ctx *fasthttp.RequestCtx) {
+	h := &ctx.Request.Header
+	body := make([]byte, 0, len(h.Method())+len(h.RequestURI())+len(h.Protocol())+2)
+	body = append(body, h.Method()...)
+	body = append(body, ' ')
+	body = append(body, h.RequestURI()...)
+	body = append(body, ' ')
+	body = append(body, h.Protocol()...)
+
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType("text/plain; charset=utf-8")
+	ctx.SetBody(body)
+	atomic.AddUint64(&totalEchoLineResponses, 1)
+	atomic.AddUint64(&totalEchoLineBytes, uint64(len(body)))
+}
@@ -1,14 +1,19 @@
 package main
 
 import (
+	"bytes"
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"log"
+	"math"
 	"net"
 	"os"
 	"os/signal"
+	"regexp"
 	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -18,12 +23,23 @@ import (
 	"github.com/valyala/fasthttp"
 )
 
+// serverName is sent in the Server response header.
+const serverName = "fast-ok-server"
+
 var (
-	totalRequests uint64
-	totalBytes    uint64
-	startTime     = time.Now()
+	totalRequests        uint64
+	totalBytes           uint64
+	totalHealthChecks    uint64
+	totalHandlerTimeouts uint64
+	totalMethodOverrides uint64
+	concurrentRequests   int64
+	lastIntervalRPS      uint64
+	startTime            = time.Now()
 )
 
+// lastTraceID is the latest -request-trace-header value seen.
+var lastTraceID atomic.Pointer[string]
+
 type hostStats struct {
 	requests uint64
 	bytes    uint64
@@ -42,19 +58,727 @@ var (
 
 func main() {
 	addr := flag.String("addr", ":8080", "TCP address to listen on")
+	mode := flag.String("mode", "ok", "Response mode: ok, grpc-web, http-upgrade, multicast, proxy, binary, close-after-headers, absorb, sse-close, redirect-loop, random-delay, echo-latency, reject-pipeline, sleep-until, reset-on-pattern, half-open, websocket-broadcast, chunked-trailers, request-smuggling-detect, connection-reset-storm, delay-first-byte, very-long-header-value, random-status-and-body, http-200-with-connection-close, http-tunnel, protocol-error, upgrade-required, websocket-ping-pong, large-headers, noop-handler, echo-request-line, drain-slow, websocket-subprotocol, post-only, partial-response")
 	statsEvery := flag.Duration("stats", 2*time.Second, "How often to print stats")
 	readTimeout := flag.Duration("read-timeout", 1*time.Second, "Read timeout")
 	writeTimeout := flag.Duration("write-timeout", 1*time.Second, "Write timeout")
 	idleTimeout := flag.Duration("idle-timeout", 30*time.Second, "Idle timeout")
 	topN := flag.Int("top", 5, "How many hosts to show per interval")
+	reportEvery := flag.Int("report-interval-count", 1, "Only log stats every N -stats ticks, aggregated over those ticks")
+	responseProto := flag.String("response-proto", "HTTP/1.1", "HTTP protocol version sent in the response status line (e.g. HTTP/1.0)")
+	trackQueryParams := flag.Bool("track-query-params", false, "Count requests per query param key and show the most common keys")
+	latencySim := flag.String("backend-latency-simulation", "", "Sleep per request according to a distribution: normal:mean_ms:stddev_ms, lognormal:mean_ms:sigma or bimodal:mean1:mean2:pct1")
+	drainDelay := flag.Duration("connect-drain-delay", 0, "After each response wait up to this long for the client to half-close the connection before closing it (0 = disabled)")
+	responseBody := flag.String("response-body", "OK", "Response body")
+	bodyPrefix := flag.String("response-body-prefix", "", "String prepended to the response body")
+	bodySuffix := flag.String("response-body-suffix", "", "String appended to the response body")
+	responseXML := flag.Bool("response-xml", false, "Wrap the response body in a minimal XML envelope")
+	responseJSON := flag.Bool("response-json", false, "Wrap the response body in a JSON object with status, server and time fields")
+	bodyEncoding := flag.String("body-encoding", "utf-8", "Character encoding of the response body: utf-8, latin-1 or base64")
+	statsHeader := flag.Bool("stats-header", false, "Add a header with live server stats (rps, concurrent, total) to every response")
+	statsHeaderName := flag.String("stats-header-name", "X-FastOK-Stats", "Header name used by -stats-header")
+	idleCloseAfter := flag.Duration("idle-close-after", 0, "Close connections without requests for this long, checked by the application (0 = disabled)")
+	maxURILength := flag.Int("max-uri-length", 0, "Reject requests with a longer request URI than this with 414 (0 = unlimited)")
+	maxHeaderSize := flag.Int("max-header-size", 0, "Reject requests whose header block is larger than this many bytes with 431 (0 = unlimited)")
+	maxHeaderCount := flag.Int("max-header-count", 0, "Reject requests with more headers than this with 431 (0 = unlimited)")
+	tlsCert := flag.String("tls-cert", "", "TLS certificate file (PEM); enables TLS together with -tls-key")
+	tlsKey := flag.String("tls-key", "", "TLS private key file (PEM)")
+	minTLSVersion := flag.String("min-tls-version", "1.2", "Lowest accepted TLS version: 1.0, 1.1, 1.2 or 1.3")
+	cipherSuites := flag.String("cipher-suites", "", "Comma separated TLS cipher suites to allow (e.g. TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384); TLS 1.3 suites are not configurable")
+	ocspStapling := flag.Bool("ocsp-stapling", false, "Fetch and staple OCSP responses for the TLS certificate (needs the issuer in -tls-cert)")
+	requestIDHeader := flag.String("request-id-header", "", "Response header carrying a generated request ID (e.g. X-Request-Id)")
+	requestIDPropagate := flag.Bool("request-id-propagate", false, "Copy inbound X-Request-Id/X-Correlation-Id to the response instead of generating an ID")
+	chunked := flag.Bool("chunked", false, "Send the response body with chunked transfer encoding")
+	trailerCRC := flag.Bool("response-trailer-crc", false, "With -chunked, send the body's CRC32 in an X-Body-CRC32 trailer")
+	multicastAddr := flag.String("multicast-addr", "", "UDP multicast group to publish stats JSON to in -mode multicast (e.g. 224.0.0.1:9999)")
+	grpcErrorRate := flag.Float64("grpc-error-rate", 0, "Fraction (0.0-1.0) of gRPC-Web calls answered with one of -grpc-status-codes")
+	grpcStatusCodeNames := flag.String("grpc-status-codes", "UNAVAILABLE", "Comma separated gRPC status code names returned for failed gRPC-Web calls")
+	upstream := flag.String("upstream", "", "Upstream base URL requests are forwarded to in -mode proxy (e.g. http://127.0.0.1:9000)")
+	upstreamPool := flag.String("upstream-pool", "", "Comma separated upstream base URLs load-balanced in -mode proxy (instead of -upstream)")
+	lbAlgo := flag.String("lb-algo", "round-robin", "Upstream pool balancing in -mode proxy: round-robin, random or least-connections")
+	upstreamTimeout := flag.Duration("upstream-timeout", 5*time.Second, "Timeout for upstream requests in -mode proxy")
+	proxyStripHeaders := flag.String("proxy-strip-headers", "", "Comma separated request headers removed before forwarding in -mode proxy")
+	proxyAddHeaders := flag.String("proxy-add-headers", "", "Comma separated key:value request headers added before forwarding in -mode proxy")
+	healthPaths := flag.String("health-paths", "", "Comma separated health check paths (e.g. /healthz) counted separately and excluded from latency stats")
+	binaryPattern := flag.String("binary-pattern", "zeros", "Body pattern in -mode binary: zeros, ones, alternating, random or counter")
+	responseBodySize := flag.Int("response-body-size", 0, "Response body size in bytes for -mode binary (0 = the full 64KB pattern)")
+	procNetStats := flag.Bool("proc-net-stats", false, "Show TCP state counts for the listen port from /proc/net/tcp (Linux only)")
+	watch := flag.Bool("watch", false, "Restart the server when its binary changes on disk")
+	watchInterval := flag.Duration("watch-interval", 2*time.Second, "How often -watch checks the binary")
+	bindRetryCount := flag.Int("bind-retry-count", 3, "How often to retry binding the listen address while it is in use")
+	bindRetryDelay := flag.Duration("bind-retry-delay", 500*time.Millisecond, "Delay between bind retries")
+	finalStatsFile := flag.String("final-stats-file", "", "Write the final stats as JSON to this file on exit")
+	shutdownWriteStats := flag.Bool("shutdown-write-stats", true, "Only write -final-stats-file after a clean shutdown")
+	responseMD5 := flag.Bool("response-md5", false, "Set a Content-MD5 header on ok responses")
+	tcpRSTAfterResponse := flag.Bool("tcp-rst-after-response", false, "Close the connection with a TCP RST instead of a FIN after each response")
+	timeSwitch := flag.String("time-of-day-response-switch", "", "Comma-separated HH:MM-HH:MM:code:body windows (local time) overriding the ok response")
+	upstreamFailureRate := flag.Float64("upstream-failure-injection", 0, "Fraction (0-1) of requests in -mode proxy answered with -upstream-failure-code without calling an upstream")
+	upstreamFailureCode := flag.Int("upstream-failure-code", fasthttp.StatusBadGateway, "Status code for injected upstream failures")
+	gzipResponses := flag.Bool("gzip", false, "Gzip ok responses for clients that accept it")
+	compressionLevel := flag.String("response-compression-level", "-1", "Gzip level: 1 (fastest) to 9 (best), -1 for the default, or speed")
+	accessLogPath := flag.String("access-log", "", "Write a JSON access log line per request to this file (- for stdout)")
+	logRequestBody := flag.Bool("log-request-body", false, "Include the request body in -access-log entries")
+	maxRequestBodyLog := flag.Int("max-request-body-log", 256, "Truncate request bodies in -access-log entries to this many bytes")
+	connLabelHeader := flag.String("connection-label-header", "", "Label each connection with this header's value on its first request (e.g. X-Connection-Label)")
+	verbose := flag.Bool("verbose", false, "Log every request")
+	forceHTTP10 := flag.Bool("force-http10", false, "Answer every request like an HTTP/1.0 server: HTTP/1.0 status line, Content-Length bodies and no keep-alive")
+	absorbMaxBytes := flag.Int64("absorb-max-bytes", 0, "Close -mode absorb connections after reading this many bytes (0 = no limit)")
+	absorbTimeout := flag.Duration("absorb-timeout", 0, "Close -mode absorb connections after this long (0 = no limit)")
+	backoffHeader := flag.Bool("backoff-header", false, "Add a Retry-After header to every 429 and 503 response")
+	retryAfterBase := flag.Int("retry-after-base", 1, "Retry-After seconds sent with -backoff-header")
+	retryAfterJitter := flag.Int("retry-after-jitter", 0, "Add a random 0 to N seconds to Retry-After")
+	loadEndpoint := flag.String("load-endpoint", "/load", "Path serving current load metrics as JSON, excluded from all stats (empty disables)")
+	histogramFile := flag.String("histogram-file", "", "After each stats interval, write the cumulative latency histogram to this file in a binary format")
+	sseCloseDelay := flag.Duration("sse-close-delay", 0, "Hold reconnecting clients for this long before their next stream in -mode sse-close")
+	redirectLoopDepth := flag.Int("redirect-loop-depth", 10, "Number of distinct hops in -mode redirect-loop")
+	bodyCounter := flag.Bool("response-body-counter", false, "Append the request number to the response body, e.g. OK-42")
+	dedupWindow := flag.Duration("request-dedup-window", 0, "Answer repeats of a request (method, path, body start) within this window with the first response's status")
+	trackTimingPerHost := flag.Bool("track-timing-per-host", false, "Keep a latency histogram per host and report its p99 in the host stats")
+	maxTrackedHosts := flag.Int("max-tracked-hosts", 1000, "Maximum number of hosts with their own latency histogram")
+	reportMarkdown := flag.Bool("report-markdown", false, "Also print each stats interval as a Markdown table on stdout (implies -track-timing-per-host)")
+	requestTimeout := flag.Duration("request-timeout", 0, "Close the connection of requests still being handled after this long (0 = off)")
+	paretoShape := flag.Float64("pareto-shape", 1.5, "Shape (alpha) of the -mode random-delay Pareto distribution")
+	paretoScaleMs := flag.Float64("pareto-scale-ms", 10, "Scale (minimum delay) in ms of the -mode random-delay Pareto distribution")
+	exposeServerInfo := flag.Bool("expose-server-info", false, "Send server, Go and fasthttp versions in the Server header")
+	maxKeepaliveRequests := flag.Int("max-keepalive-requests", 0, "Close connections after this many requests (0 = unlimited)")
+	keepAliveTimeout := flag.Duration("keep-alive-timeout", 0, "Advertise this timeout (and -max-keepalive-requests) to clients in a Keep-Alive response header")
+	drainOnShutdown := flag.Bool("drain-on-shutdown", false, "On shutdown, wait for requests still being received or handled before closing connections")
+	drainTimeout := flag.Duration("drain-timeout", 10*time.Second, "Maximum time -drain-on-shutdown waits")
+	traceHeader := flag.String("request-trace-header", "", "Show the last seen value of this request header (e.g. X-Trace-Id) in the total stats line")
+	wakeTime := flag.String("wake-time", "", "RFC3339 time until which -mode sleep-until holds all requests")
+	logHookCmd := flag.String("log-hook-cmd", "", "Shell command run per request with its summary as a JSON line on stdin")
+	logHookSampleRate := flag.Int("log-hook-sample-rate", 1, "Run -log-hook-cmd only for every Nth request")
+	logHookWorkers := flag.Int("log-hook-workers", 4, "Number of -log-hook-cmd commands run concurrently")
+	resetPattern := flag.String("reset-pattern", "", "Regexp; -mode reset-on-pattern RSTs connections whose request body matches it")
+	regionHeader := flag.String("region-header", "", "Report requests, bytes and errors per value of this request header (e.g. X-Region)")
+	broadcastInterval := flag.Duration("broadcast-interval", time.Second, "How often -mode websocket-broadcast sends a message to all clients")
+	stormFraction := flag.Float64("storm-fraction", 0.5, "Fraction of connections (0-1) that -mode connection-reset-storm resets during a storm")
+	stormInterval := flag.Duration("storm-interval", 10*time.Second, "How often -mode connection-reset-storm starts a storm")
+	stormDuration := flag.Duration("storm-duration", 2*time.Second, "How long each -mode connection-reset-storm storm lasts")
+	firstByteDelay := flag.Duration("first-byte-delay", time.Second, "How long -mode delay-first-byte waits after reading a request before sending anything")
+	longHeaderName := flag.String("long-header-name", "X-Padding", "Name of the response header added by -mode very-long-header-value")
+	longHeaderSize := flag.Int("long-header-size", 8192, "Length in bytes of the -mode very-long-header-value header value")
+	responseWeights := flag.String("response-weights", "200:70,201:10,400:5,404:10,500:5", "Comma separated code:weight pairs picking the status code in -mode random-status-and-body")
+	startupDelay := flag.Duration("startup-delay", 0, "Wait this long after binding the listener before accepting connections")
+	cpuLimit := flag.Float64("cpu-limit", 0, "Throttle requests to keep CPU use at this many CPUs on average, e.g. 0.5 for half a core (0 = unlimited)")
+	influxAddr := flag.String("influxdb-addr", "", "If set, send stats each interval to this InfluxDB UDP address (host:port) as line protocol")
+	influxMeasurement := flag.String("influxdb-measurement", "fast_ok", "Measurement name for -influxdb-addr points")
+	tunnelAllow := flag.String("tunnel-allow-list", "", "Comma separated CIDRs and hostname globs that -mode http-tunnel may connect to (empty allows any)")
+	hostEvictAfterIdle := flag.Int("host-evict-after-idle", 0, "Forget a host's stats after this many consecutive stats intervals without requests (0 = never)")
+	protocolErrorRate := flag.Float64("protocol-error-rate", 0.1, "Fraction of requests (0-1) that -mode protocol-error answers with a malformed response")
+	bodyRepeat := flag.Int("body-repeat", 1, "Repeat -response-body this many times (any prefix and suffix are added once)")
+	methodOverrideHeader := flag.String("method-override-header", "", "Header (e.g. X-HTTP-Method-Override) whose value replaces POST as the method in method stats")
+	rateLimit := flag.Float64("rate-limit", 0, "Per-IP request rate limit in requests per second, answered with 429 when exceeded (0 = unlimited)")
+	rateLimitBurst := flag.Int("rate-limit-burst", 10, "Requests an IP may send at once above -rate-limit, refilled at the limit rate")
+	stickyHeader := flag.String("sticky-session-header", "", "Header (e.g. X-Session-Id) whose value picks the instance a request should reach; misrouted requests get X-Should-Route-To")
+	numInstances := flag.Int("num-instances", 1, "Number of instances sessions are spread over with -sticky-session-header")
+	thisInstanceID := flag.Int("this-instance-id", 0, "This instance's ID (0 to -num-instances minus 1) for -sticky-session-header")
+	wsPingInterval := flag.Duration("ws-ping-interval", time.Second, "How often -mode websocket-ping-pong pings each client")
+	extraHeadersCount := flag.Int("extra-headers-count", 50, "Number of response headers added by -mode large-headers")
+	extraHeaderSize := flag.Int("extra-header-size", 64, "Length in bytes of each -mode large-headers header value")
+	gzipFile := flag.String("response-body-gzip-file", "", "Serve this already gzip compressed file as the body with Content-Encoding: gzip, without decompressing it")
+	drainRate := flag.Int("drain-rate-bps", 1024, "Rate in bytes per second at which -mode drain-slow reads request bodies")
+	drainMaxBytes := flag.Int("drain-max-bytes", 1<<20, "Refuse -mode drain-slow request bodies longer than this with 413")
+	wsSubprotocol := flag.String("ws-subprotocol", "", "WebSocket subprotocol clients must request; upgrades without it get 400 (required with -mode websocket-subprotocol)")
+	statsColsSpec := flag.String("stats-cols", "", "Comma separated columns for the total stats line, any of rps, bps, conns, avg, totals, methods, uptime, concurrent, p50, p90, p99, trace (empty = the default line)")
+	partialBytes := flag.Int("partial-bytes", 1, "Body bytes -mode partial-response sends before closing the connection")
 	flag.Parse()
+	if *reportMarkdown {
+		*trackTimingPerHost = true
+	}
+
+	name := serverName
+	if *exposeServerInfo {
+		name = serverInfo()
+	}
 
 	// Remove timestamps from default logger output
 	log.SetFlags(0)
 
-	log.Printf("fast-ok-server starting on %s (GOMAXPROCS=%d)", *addr, runtime.GOMAXPROCS(0))
+	if !strings.HasPrefix(*responseProto, "HTTP/") {
+		log.Fatalf("invalid -response-proto %q: must look like HTTP/1.x", *responseProto)
+	}
+	if *forceHTTP10 {
+		// HTTP/1.0 has no chunked transfer coding, so bodies always go out
+		// with a Content-Length
+		if *chunked {
+			log.Printf("warning: -chunked is ignored with -force-http10")
+			*chunked = false
+		}
+		*responseProto = "HTTP/1.0"
+	}
+	protoOverride := []byte(*responseProto)
+	overrideProto := *responseProto != "HTTP/1.1"
+	closeAfterResponse := *responseProto == "HTTP/1.0"
+
+	if *cpuLimit < 0 {
+		log.Fatalf("invalid -cpu-limit %v: must not be negative", *cpuLimit)
+	}
+	if *numInstances < 1 || *thisInstanceID < 0 || *thisInstanceID >= *numInstances {
+		log.Fatalf("invalid -num-instances %d / -this-instance-id %d: need at least one instance and an ID below the count", *numInstances, *thisInstanceID)
+	}
+
+	if *rateLimit < 0 || *rateLimitBurst < 1 {
+		log.Fatalf("invalid -rate-limit %v / -rate-limit-burst %d: rate must not be negative and burst must be at least 1", *rateLimit, *rateLimitBurst)
+	}
+	var rateLimiter *tokenBucketLimiter
+	if *rateLimit > 0 {
+		rateLimiter = newTokenBucketLimiter(*rateLimit, *rateLimitBurst)
+	}
+
+	var cpuThrottle *cpuLimiter
+	if *cpuLimit > 0 {
+		cpuThrottle = newCPULimiter(*cpuLimit)
+	}
+
+	var sampleLatency func() time.Duration
+	if *latencySim != "" {
+		var err error
+		if sampleLatency, err = parseLatencyDistribution(*latencySim); err != nil {
+			log.Fatalf("invalid -backend-latency-simulation %q: %v", *latencySim, err)
+		}
+	}
+
+	if *maxKeepaliveRequests < 0 {
+		log.Fatalf("invalid -max-keepalive-requests %d: must not be negative", *maxKeepaliveRequests)
+	}
+
+	if *retryAfterBase < 0 || *retryAfterJitter < 0 {
+		log.Fatalf("invalid -retry-after-base %d / -retry-after-jitter %d: must not be negative", *retryAfterBase, *retryAfterJitter)
+	}
+
+	var accessLog *accessLogger
+	if *accessLogPath != "" {
+		if *maxRequestBodyLog < 0 {
+			log.Fatalf("invalid -max-request-body-log %d: must not be negative", *maxRequestBodyLog)
+		}
+		var err error
+		if accessLog, err = newAccessLogger(*accessLogPath, *logRequestBody, *maxRequestBodyLog); err != nil {
+			log.Fatalf("invalid -access-log %q: %v", *accessLogPath, err)
+		}
+	}
+
+	var hook *logHook
+	if *logHookCmd != "" {
+		if *logHookSampleRate < 1 || *logHookWorkers < 1 {
+			log.Fatalf("invalid -log-hook-sample-rate %d / -log-hook-workers %d: must be at least 1", *logHookSampleRate, *logHookWorkers)
+		}
+		hook = newLogHook(*logHookCmd, *logHookSampleRate, *logHookWorkers)
+	}
+
+	var timeWindows []timeWindow
+	if *timeSwitch != "" {
+		var err error
+		if timeWindows, err = parseTimeWindows(*timeSwitch); err != nil {
+			log.Fatalf("invalid -time-of-day-response-switch %q: %v", *timeSwitch, err)
+		}
+	}
+
+	if *trailerCRC && !*chunked {
+		log.Fatalf("-response-trailer-crc requires -chunked")
+	}
+	if *ocspStapling && *tlsCert == "" {
+		log.Fatalf("-ocsp-stapling requires -tls-cert and -tls-key")
+	}
+
+	var tlsConfig *tls.Config
+	if *tlsCert != "" || *tlsKey != "" {
+		cert, err := tls.LoadX509KeyPair(*tlsCert, *tlsKey)
+		if err != nil {
+			log.Fatalf("tls error: %v", err)
+		}
+		minVersion, err := parseTLSVersion(*minTLSVersion)
+		if err != nil {
+			log.Fatalf("invalid -min-tls-version %q: %v", *minTLSVersion, err)
+		}
+		tlsConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   minVersion,
+		}
+		if *cipherSuites != "" {
+			ids, err := parseCipherSuites(*cipherSuites)
+			if err != nil {
+				log.Fatalf("invalid -cipher-suites: %v", err)
+			}
+			if minVersion == tls.VersionTLS13 || tls13Only(ids) {
+				log.Printf("warning: -cipher-suites only applies to TLS 1.2 and lower, TLS 1.3 suites are always enabled")
+			}
+			tlsConfig.CipherSuites = ids
+		}
+		if *ocspStapling {
+			stapler, err := newOCSPStapler(cert)
+			if err != nil {
+				log.Fatalf("ocsp error: %v", err)
+			}
+			resp, err := stapler.refresh()
+			if err != nil {
+				log.Fatalf("ocsp error: %v", err)
+			}
+			next := ocspRefreshTime(resp, time.Now())
+			log.Printf("OCSP stapling enabled: status=%s, next refresh %s", ocspStatusName(resp.Status), next.Format(time.RFC3339))
+			go stapler.run(next)
+			tlsConfig.Certificates = nil
+			tlsConfig.GetCertificate = stapler.getCertificate
+		}
+		log.Printf("TLS enabled, minimum version %s", tls.VersionName(minVersion))
+	}
+
+	if *bodyRepeat < 1 {
+		log.Fatalf("invalid -body-repeat %d: must be at least 1", *bodyRepeat)
+	}
+	*responseBody = strings.Repeat(*responseBody, *bodyRepeat)
+
+	okBody, okContentType, err := encodeBody([]byte(*bodyPrefix+*responseBody+*bodySuffix), *bodyEncoding)
+	if err != nil {
+		log.Fatalf("invalid -body-encoding %q: %v", *bodyEncoding, err)
+	}
+
+	if *responseXML {
+		if *bodyEncoding != "utf-8" {
+			log.Fatalf("-response-xml requires -body-encoding utf-8")
+		}
+		okBody = xmlEnvelope([]byte(*bodyPrefix + *responseBody + *bodySuffix))
+		okContentType = "application/xml; charset=utf-8"
+	}
+
+	if *responseJSON {
+		if *responseXML || *bodyEncoding != "utf-8" {
+			log.Fatalf("-response-json can't be combined with -response-xml or -body-encoding other than utf-8")
+		}
+		if okBody, err = jsonEnvelopePrefix(*bodyPrefix + *responseBody + *bodySuffix); err != nil {
+			log.Fatalf("json error: %v", err)
+		}
+		okContentType = "application/json"
+	}
+
+	if *bodyCounter && (*responseJSON || *responseXML) {
+		log.Fatalf("-response-body-counter can't be combined with -response-json or -response-xml")
+	}
+	preGzipped := *gzipFile != ""
+	if preGzipped {
+		if *responseJSON || *responseXML || *bodyCounter || *gzipResponses {
+			log.Fatalf("-response-body-gzip-file can't be combined with -response-json, -response-xml, -response-body-counter or -gzip")
+		}
+		if okBody, err = loadGzipFile(*gzipFile); err != nil {
+			log.Fatalf("invalid -response-body-gzip-file: %v", err)
+		}
+	}
+
+	// the body only varies per request with -response-json or
+	// -response-body-counter
+	staticBody := !*responseJSON && !*bodyCounter
+
+	var gz *gzipper
+	var okGzipBody []byte
+	if *gzipResponses {
+		level, err := parseCompressionLevel(*compressionLevel)
+		if err != nil {
+			log.Fatalf("invalid -response-compression-level %q: %v", *compressionLevel, err)
+		}
+		gz = newGzipper(level)
+		if staticBody {
+			okGzipBody = gz.compress(okBody)
+		}
+	}
+
+	// A static body is compressed and hashed once here. Per-request work is
+	// part of the handler time and thus shows up in the latency histogram.
+	var okMD5, okGzipMD5 string
+	if *responseMD5 && staticBody {
+		okMD5 = contentMD5(okBody)
+		if okGzipBody != nil {
+			okGzipMD5 = contentMD5(okGzipBody)
+		}
+	}
+
+	respondOK := func(ctx *fasthttp.RequestCtx) {
+		body, sum, gzipBody, gzipSum := okBody, okMD5, okGzipBody, okGzipMD5
+		// a time window override replaces the status and body, but is
+		// otherwise finished like any other response
+		override := false
+		if code := atomic.LoadInt64(&currentCode); code != 0 {
+			ctx.SetStatusCode(int(code))
+			ctx.SetContentType("text/plain; charset=utf-8")
+			body, sum, gzipBody, gzipSum = *currentBody.Load(), "", nil, ""
+			override = true
+		} else {
+			ctx.SetStatusCode(fasthttp.StatusOK)
+			ctx.SetContentType(okContentType)
+			if preGzipped {
+				// sent as is, even to clients that don't accept gzip
+				ctx.Response.Header.Set("Content-Encoding", "gzip")
+				atomic.AddUint64(&totalGzipFileResponses, 1)
+				atomic.AddUint64(&totalGzipFileBytes, uint64(len(okBody)))
+			}
+		}
+		compress := false
+		if gz != nil {
+			ctx.Response.Header.Set("Vary", "Accept-Encoding")
+			compress = acceptsGzip(&ctx.Request.Header)
+		}
+		if *chunked || compress {
+			if !override && *responseJSON {
+				body = appendJSONTime(append([]byte(nil), okBody...), ctx.Time())
+			}
+			if !override && *bodyCounter {
+				body = appendBodyCounter(append([]byte(nil), okBody...), atomic.LoadUint64(&totalRequests))
+			}
+			if compress {
+				n := len(body)
+				if gzipBody != nil {
+					body, sum = gzipBody, gzipSum
+				} else {
+					body = gz.compress(body)
+				}
+				countGzip(n, len(body))
+				ctx.Response.Header.Set("Content-Encoding", "gzip")
+			}
+			if *responseMD5 {
+				if sum == "" {
+					sum = contentMD5(body)
+				}
+				ctx.Response.Header.Set("Content-MD5", sum)
+			}
+			if *chunked {
+				ctx.SetBodyStream(newChunkedBody(body, &ctx.Response.Header, *trailerCRC), -1)
+			} else {
+				ctx.SetBody(body)
+			}
+			return
+		}
+		ctx.SetBody(body)
+		if !override && *responseJSON {
+			var buf [32]byte
+			ctx.Response.AppendBody(appendJSONTime(buf[:0], ctx.Time()))
+		}
+		if !override && *bodyCounter {
+			var buf [24]byte
+			ctx.Response.AppendBody(appendBodyCounter(buf[:0], atomic.LoadUint64(&totalRequests)))
+		}
+		if *responseMD5 {
+			if sum != "" {
+				ctx.Response.Header.Set("Content-MD5", sum)
+			} else {
+				ctx.Response.Header.Set("Content-MD5", contentMD5(ctx.Response.Body()))
+			}
+		}
+	}
+
+	var modeHandler fasthttp.RequestHandler
+	var proxyMode *proxy
+	switch *mode {
+	case "ok":
+		modeHandler = respondOK
+	case "grpc-web":
+		codes, err := parseGRPCStatusCodes(*grpcStatusCodeNames)
+		if err != nil {
+			log.Fatalf("invalid -grpc-status-codes: %v", err)
+		}
+		if *grpcErrorRate < 0 || *grpcErrorRate > 1 {
+			log.Fatalf("invalid -grpc-error-rate %v: must be between 0 and 1", *grpcErrorRate)
+		}
+		modeHandler = newGRPCWebHandler(*grpcErrorRate, codes)
+	case "multicast":
+		// responses are the same as in ok mode, stats are additionally
+		// published to the multicast group
+		modeHandler = respondOK
+	case "proxy":
+		pool := strings.Split(*upstreamPool, ",")
+		if *upstream != "" {
+			pool = append(pool, *upstream)
+		}
+		p, err := newProxy(pool, *lbAlgo, *upstreamTimeout, *proxyStripHeaders, *proxyAddHeaders)
+		if err != nil {
+			log.Fatalf("invalid proxy config: %v", err)
+		}
+		if *upstreamFailureRate < 0 || *upstreamFailureRate > 1 {
+			log.Fatalf("invalid -upstream-failure-injection %v: must be between 0 and 1", *upstreamFailureRate)
+		}
+		if *upstreamFailureCode < 100 || *upstreamFailureCode > 999 {
+			log.Fatalf("invalid -upstream-failure-code %d", *upstreamFailureCode)
+		}
+		p.failureRate, p.failureCode = *upstreamFailureRate, *upstreamFailureCode
+		proxyMode = p
+		modeHandler = p.handle
+	case "binary":
+		pattern, err := buildBinaryPattern(*binaryPattern)
+		if err != nil {
+			log.Fatalf("invalid -binary-pattern %q: %v", *binaryPattern, err)
+		}
+		if *responseBodySize < 0 || *responseBodySize > len(pattern) {
+			log.Fatalf("invalid -response-body-size %d: must be between 0 and %d in -mode binary", *responseBodySize, len(pattern))
+		}
+		if *responseBodySize > 0 {
+			pattern = pattern[:*responseBodySize]
+		}
+		modeHandler = newBinaryHandler(pattern)
+	case "http-upgrade":
+		modeHandler = func(ctx *fasthttp.RequestCtx) {
+			if !respondUpgrade(ctx) {
+				respondOK(ctx)
+			}
+		}
+	case "websocket-subprotocol":
+		if *wsSubprotocol == "" {
+			log.Fatalf("-mode websocket-subprotocol needs -ws-subprotocol")
+		}
+		modeHandler = newWSHub(*wsSubprotocol).handle
+	case "websocket-ping-pong":
+		if *wsPingInterval <= 0 {
+			log.Fatalf("invalid -ws-ping-interval %s: must be positive", *wsPingInterval)
+		}
+		hub := newWSHub(*wsSubprotocol)
+		go hub.ping(*wsPingInterval)
+		modeHandler = hub.handle
+	case "websocket-broadcast":
+		if *broadcastInterval <= 0 {
+			log.Fatalf("invalid -broadcast-interval %s: must be positive", *broadcastInterval)
+		}
+		hub := newWSHub(*wsSubprotocol)
+		go hub.broadcast(*broadcastInterval)
+		modeHandler = hub.handle
+	case "half-open":
+		log.Printf("warning: -mode half-open never responds; connections stay open until clients give up")
+		modeHandler = respondHalfOpen
+	case "reset-on-pattern":
+		// an empty regexp matches every body
+		if *resetPattern == "" {
+			log.Fatalf("-mode reset-on-pattern requires -reset-pattern")
+		}
+		re, err := regexp.Compile(*resetPattern)
+		if err != nil {
+			log.Fatalf("invalid -reset-pattern %q: %v", *resetPattern, err)
+		}
+		modeHandler = newResetOnPatternHandler(re, respondOK)
+	case "sleep-until":
+		wake, err := time.Parse(time.RFC3339, *wakeTime)
+		if err != nil {
+			log.Fatalf("invalid -wake-time %q: %v", *wakeTime, err)
+		}
+		modeHandler = newSleepUntilHandler(wake, respondOK)
+	case "reject-pipeline":
+		modeHandler = newRejectPipelineHandler(respondOK)
+	case "echo-latency":
+		// the body is the time the request reached the handler, in ns since
+		// the Unix epoch, for clients to subtract from their receive time
+		modeHandler = func(ctx *fasthttp.RequestCtx) {
+			var buf [20]byte
+			ctx.SetStatusCode(fasthttp.StatusOK)
+			ctx.SetContentType("text/plain; charset=us-ascii")
+			ctx.Response.Header.Set("Cache-Control", "no-store")
+			ctx.SetBody(strconv.AppendInt(buf[:0], ctx.Time().UnixNano(), 10))
+		}
+	case "partial-response":
+		if *partialBytes < 0 {
+			log.Fatalf("invalid -partial-bytes %d: must not be negative", *partialBytes)
+		}
+		modeHandler = newPartialResponseHandler(respondOK, name, *partialBytes)
+	case "post-only":
+		modeHandler = newPostOnlyHandler(respondOK)
+	case "drain-slow":
+		if *drainRate < 1 {
+			log.Fatalf("invalid -drain-rate-bps %d: must be positive", *drainRate)
+		}
+		if *drainMaxBytes < 0 {
+			log.Fatalf("invalid -drain-max-bytes %d: must not be negative", *drainMaxBytes)
+		}
+		// the body is read by drainSlowly before the handler gets to it
+		modeHandler = respondOK
+	case "echo-request-line":
+		modeHandler = respondEchoLine
+	case "upgrade-required":
+		modeHandler = respondUpgradeRequired
+	case "protocol-error":
+		if *protocolErrorRate < 0 || *protocolErrorRate > 1 {
+			log.Fatalf("invalid -protocol-error-rate %v: must be between 0 and 1", *protocolErrorRate)
+		}
+		modeHandler = newProtocolErrorHandler(*protocolErrorRate, respondOK)
+	case "http-tunnel":
+		allow, err := parseTunnelAllowList(*tunnelAllow)
+		if err != nil {
+			log.Fatalf("invalid -tunnel-allow-list %q: %v", *tunnelAllow, err)
+		}
+		if allow.empty() {
+			log.Printf("warning: -mode http-tunnel without -tunnel-allow-list is an open proxy to any host")
+		}
+		modeHandler = newTunnelHandler(allow, respondOK)
+	case "http-200-with-connection-close":
+		modeHandler = newConnectionCloseHandler(respondOK, name)
+	case "random-status-and-body":
+		weights, err := parseResponseWeights(*responseWeights)
+		if err != nil {
+			log.Fatalf("invalid -response-weights %q: %v", *responseWeights, err)
+		}
+		modeHandler = newRandomStatusHandler(weights)
+	case "large-headers":
+		if *extraHeadersCount < 0 || *extraHeaderSize < 0 {
+			log.Fatalf("invalid -extra-headers-count %d / -extra-header-size %d: must not be negative", *extraHeadersCount, *extraHeaderSize)
+		}
+		extra := make([][2][]byte, *extraHeadersCount)
+		for i := range extra {
+			extra[i] = [2][]byte{
+				strconv.AppendInt([]byte("X-Extra-Header-"), int64(i+1), 10),
+				randomHeaderValue(*extraHeaderSize),
+			}
+		}
+		modeHandler = func(ctx *fasthttp.RequestCtx) {
+			respondOK(ctx)
+			for _, kv := range extra {
+				// the names are unique, so skip the lookup Set would do
+				ctx.Response.Header.AddBytesKV(kv[0], kv[1])
+			}
+		}
+	case "very-long-header-value":
+		if *longHeaderName == "" || *longHeaderSize < 0 {
+			log.Fatalf("invalid -long-header-name %q / -long-header-size %d", *longHeaderName, *longHeaderSize)
+		}
+		hdrName, hdrValue := []byte(*longHeaderName), randomHeaderValue(*longHeaderSize)
+		modeHandler = func(ctx *fasthttp.RequestCtx) {
+			respondOK(ctx)
+			ctx.Response.Header.SetBytesKV(hdrName, hdrValue)
+		}
+	case "delay-first-byte":
+		if *firstByteDelay < 0 {
+			log.Fatalf("invalid -first-byte-delay %s: must not be negative", *firstByteDelay)
+		}
+		// fasthttp has read the whole request by the time the handler runs
+		// and writes nothing until it returns, so the wait comes before even
+		// the status line
+		modeHandler = func(ctx *fasthttp.RequestCtx) {
+			time.Sleep(*firstByteDelay)
+			respondOK(ctx)
+		}
+	case "random-delay":
+		sample, err := paretoSampler(*paretoShape, *paretoScaleMs)
+		if err != nil {
+			log.Fatalf("invalid -pareto-shape %v / -pareto-scale-ms %v: %v", *paretoShape, *paretoScaleMs, err)
+		}
+		modeHandler = func(ctx *fasthttp.RequestCtx) {
+			time.Sleep(sample())
+			respondOK(ctx)
+		}
+	case "redirect-loop":
+		if *redirectLoopDepth < 1 {
+			log.Fatalf("invalid -redirect-loop-depth %d: must be at least 1", *redirectLoopDepth)
+		}
+		modeHandler = newRedirectLoopHandler(*redirectLoopDepth)
+	case "sse-close":
+		// the event stream is open ended, so it can't be sent with a
+		// Content-Length
+		if *responseProto == "HTTP/1.0" {
+			log.Fatalf("-mode sse-close needs HTTP/1.1 chunked encoding")
+		}
+		modeHandler = newSSECloseHandler(*sseCloseDelay)
+	case "noop-handler":
+		// the whole handler is replaced once it's built
+		log.Printf("-mode noop-handler skips all request stats; only connection counts are kept")
+	case "absorb":
+		// connections are read raw by serveAbsorb and never reach the HTTP
+		// handler
+		if flag.Arg(0) == "benchmark" {
+			log.Fatalf("-mode absorb can't be benchmarked, it never responds")
+		}
+	case "connection-reset-storm":
+		if *stormFraction < 0 || *stormFraction > 1 {
+			log.Fatalf("invalid -storm-fraction %v: must be between 0 and 1", *stormFraction)
+		}
+		if *stormDuration <= 0 || *stormInterval <= *stormDuration {
+			log.Fatalf("invalid -storm-duration %s: must be positive and shorter than -storm-interval %s", *stormDuration, *stormInterval)
+		}
+		storm := &resetStorm{fraction: *stormFraction}
+		go storm.run(*stormInterval, *stormDuration)
+		modeHandler = storm.wrap(respondOK)
+	case "request-smuggling-detect":
+		modeHandler = newSmugglingDetectHandler(respondOK)
+	case "chunked-trailers":
+		if *responseProto == "HTTP/1.0" {
+			log.Fatalf("-mode chunked-trailers needs HTTP/1.1 chunked encoding")
+		}
+		modeHandler = newChunkedTrailersHandler(respondOK)
+	case "close-after-headers":
+		log.Printf("warning: -mode close-after-headers closes every connection before the body is sent; clients will see errors")
+		modeHandler = newCloseAfterHeadersHandler(respondOK, name)
+	default:
+		log.Fatalf("unknown -mode %q", *mode)
+	}
+
+	if *dedupWindow > 0 && modeHandler != nil {
+		modeHandler = newRequestDeduper(*dedupWindow).wrap(modeHandler)
+	}
+
+	var publisher *multicastPublisher
+	if *mode == "multicast" {
+		if *multicastAddr == "" {
+			log.Fatalf("-mode multicast requires -multicast-addr")
+		}
+		if publisher, err = newMulticastPublisher(*multicastAddr); err != nil {
+			log.Fatalf("multicast error: %v", err)
+		}
+		log.Printf("publishing stats to multicast group %s", *multicastAddr)
+	}
+	hostname, _ := os.Hostname()
+	instance := hostname + *addr
+
+	var listenPort int
+	if *procNetStats {
+		_, port, err := net.SplitHostPort(*addr)
+		if err == nil {
+			listenPort, err = strconv.Atoi(port)
+		}
+		if err != nil || listenPort == 0 {
+			log.Fatalf("-proc-net-stats needs a fixed port in -addr, got %q", *addr)
+		}
+	}
+
+	healthPathSet := make(map[string]bool)
+	for _, p := range strings.Split(*healthPaths, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			healthPathSet[p] = true
+		}
+	}
+
+	// connections are tracked individually for -idle-close-after,
+	// -drain-on-shutdown and -mode reject-pipeline
+	trackConns := *idleCloseAfter > 0 || *drainOnShutdown || *mode == "reject-pipeline"
+
+	var loadHandler fasthttp.RequestHandler
+	if *loadEndpoint != "" {
+		loadHandler = newLoadHandler()
+	}
 
 	h := func(ctx *fasthttp.RequestCtx) {
+		if loadHandler != nil && string(ctx.Path()) == *loadEndpoint {
+			loadHandler(ctx)
+			return
+		}
+
+		atomic.AddInt64(&concurrentRequests, 1)
+		defer atomic.AddInt64(&concurrentRequests, -1)
+
+		if *requestTimeout > 0 {
+			// whoever flips state first wins: the timer closing the
+			// connection or the handler finishing in time
+			var state int32
+			conn := ctx.Conn()
+			t := time.AfterFunc(*requestTimeout, func() {
+				if atomic.CompareAndSwapInt32(&state, 0, 1) {
+					atomic.AddUint64(&totalHandlerTimeouts, 1)
+					conn.Close()
+				}
+			})
+			defer func() {
+				if atomic.CompareAndSwapInt32(&state, 0, 2) {
+					t.Stop()
+				}
+			}()
+		}
+
+		// refused below, once the request has been counted
+		bodyTooLarge := false
+		if *mode == "drain-slow" {
+			bodyTooLarge = !drainSlowly(ctx, *drainRate, *drainMaxBytes, *readTimeout)
+		}
+
 		//host := strings.ToLower(string(ctx.Host()))
 		host := strings.ToLower(string(ctx.Request.Header.Host()))
 
@@ -72,6 +796,43 @@ func main() {
 		atomic.AddUint64(&totalBytes, reqSize)
 		atomic.AddUint64(&totalRequests, 1)
 
+		if trackConns {
+			if c := countingConnOf(ctx.Conn()); c != nil {
+				c.touch()
+				defer c.finish()
+			}
+		}
+
+		if tlsConfig != nil && ctx.ConnRequestNum() == 1 {
+			if cs := ctx.TLSConnectionState(); cs != nil {
+				tlsVersionCounts.inc([]byte(tls.VersionName(cs.Version)))
+			}
+		}
+
+		if *traceHeader != "" {
+			// only allocate when the value changes, which is rare for an
+			// experiment's correlation ID
+			if v := peekHeader(&ctx.Request.Header, *traceHeader); len(v) > 0 {
+				if cur := lastTraceID.Load(); cur == nil || *cur != string(v) {
+					id := string(v)
+					lastTraceID.Store(&id)
+				}
+			}
+		}
+
+		var connLabel []byte
+		if *connLabelHeader != "" {
+			if c := countingConnOf(ctx.Conn()); c != nil {
+				if ctx.ConnRequestNum() == 1 {
+					c.label = append([]byte(nil), peekHeader(&ctx.Request.Header, *connLabelHeader)...)
+				}
+				connLabel = c.label
+			}
+			if len(connLabel) > 0 {
+				connLabelCounts.inc(connLabel)
+			}
+		}
+
 		v, ok := hostMap.Load(host)
 		if !ok {
 			newHS := &hostStats{}
@@ -85,7 +846,18 @@ func main() {
 		atomic.AddUint64(&hs.requests, 1)
 		atomic.AddUint64(&hs.bytes, reqSize)
 
-		switch string(ctx.Method()) {
+		if *trackQueryParams {
+			countQueryParams(ctx.QueryArgs())
+		}
+
+		method := ctx.Method()
+		if *methodOverrideHeader != "" && ctx.IsPost() {
+			if v := bytes.TrimSpace(peekHeader(&ctx.Request.Header, *methodOverrideHeader)); len(v) > 0 {
+				atomic.AddUint64(&totalMethodOverrides, 1)
+				method = overrideMethod(v)
+			}
+		}
+		switch string(method) {
 		case fasthttp.MethodGet:
 			atomic.AddUint64(&methods.get, 1)
 		case fasthttp.MethodPost:
@@ -94,17 +866,178 @@ func main() {
 			atomic.AddUint64(&methods.other, 1)
 		}
 
-		ctx.SetStatusCode(fasthttp.StatusOK)
-		ctx.SetContentType("text/plain; charset=utf-8")
-		ctx.SetBodyString("OK")
+		if bodyTooLarge {
+			// the rest of the body is still unread
+			ctx.SetStatusCode(fasthttp.StatusRequestEntityTooLarge)
+			ctx.SetConnectionClose()
+			return
+		}
+		if *maxURILength > 0 && len(ctx.RequestURI()) > *maxURILength {
+			atomic.AddUint64(&totalURITooLong, 1)
+			ctx.SetStatusCode(fasthttp.StatusRequestURITooLong)
+			return
+		}
+		if *maxHeaderSize > 0 && len(ctx.Request.Header.RawHeaders()) > *maxHeaderSize {
+			atomic.AddUint64(&totalHeaderSizeExceeded, 1)
+			ctx.SetStatusCode(fasthttp.StatusRequestHeaderFieldsTooLarge)
+			return
+		}
+		if *maxHeaderCount > 0 && headerCount(&ctx.Request.Header) > *maxHeaderCount {
+			atomic.AddUint64(&totalHeaderCountExceeded, 1)
+			ctx.SetStatusCode(fasthttp.StatusRequestHeaderFieldsTooLarge)
+			return
+		}
+
+		if rateLimiter != nil {
+			if ok, wait := rateLimiter.allow(ctx.RemoteIP().String(), ctx.Time()); !ok {
+				atomic.AddUint64(&totalRateLimited, 1)
+				ctx.SetStatusCode(fasthttp.StatusTooManyRequests)
+				ctx.Response.Header.Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				return
+			}
+		}
+
+		if cpuThrottle != nil {
+			cpuThrottle.wait()
+		}
+
+		if sampleLatency != nil {
+			time.Sleep(sampleLatency())
+		}
+
+		if overrideProto {
+			ctx.Response.Header.SetProtocol(protoOverride)
+		}
+		if closeAfterResponse {
+			// HTTP/1.0 has no persistent connections by default
+			ctx.SetConnectionClose()
+		}
+		if atomic.LoadInt32(&draining) == 1 {
+			// keep-alive clients would otherwise keep the drain busy
+			// until it times out
+			ctx.SetConnectionClose()
+		}
+
+		if *statsHeader {
+			var buf [64]byte
+			b := append(buf[:0], "rps="...)
+			b = strconv.AppendUint(b, atomic.LoadUint64(&lastIntervalRPS), 10)
+			b = append(b, ";concurrent="...)
+			b = strconv.AppendInt(b, atomic.LoadInt64(&concurrentRequests), 10)
+			b = append(b, ";total="...)
+			b = strconv.AppendUint(b, atomic.LoadUint64(&totalRequests), 10)
+			ctx.Response.Header.SetBytesV(*statsHeaderName, b)
+		}
+
+		if *requestIDHeader != "" || *requestIDPropagate {
+			setRequestID(ctx, *requestIDHeader, *requestIDPropagate)
+		}
+
+		modeHandler(ctx)
+
+		if *stickyHeader != "" {
+			if v := peekHeader(&ctx.Request.Header, *stickyHeader); len(v) > 0 {
+				atomic.AddUint64(&totalStickyRequests, 1)
+				if want := stickyInstance(v, *numInstances); want != *thisInstanceID {
+					atomic.AddUint64(&totalMisroutedRequests, 1)
+					ctx.Response.Header.Set("X-Should-Route-To", strconv.Itoa(want))
+				}
+			}
+		}
+
+		if *backoffHeader {
+			setRetryAfter(ctx, *retryAfterBase, *retryAfterJitter)
+		}
+
+		// max counts the requests still allowed on this connection; the
+		// last one goes out with Connection: close instead
+		if *keepAliveTimeout > 0 && !ctx.Response.ConnectionClose() &&
+			(*maxKeepaliveRequests == 0 || ctx.ConnRequestNum() < uint64(*maxKeepaliveRequests)) {
+			var buf [64]byte
+			b := strconv.AppendInt(append(buf[:0], "timeout="...), int64(keepAliveTimeout.Seconds()), 10)
+			if *maxKeepaliveRequests > 0 {
+				b = strconv.AppendUint(append(b, ", max="...), uint64(*maxKeepaliveRequests)-ctx.ConnRequestNum(), 10)
+			}
+			ctx.Response.Header.SetBytesV("Keep-Alive", b)
+		}
+
+		if *tcpRSTAfterResponse {
+			if cc := countingConnOf(ctx.Conn()); cc != nil && cc.resetOnClose() == nil {
+				atomic.AddUint64(&totalRSTResponses, 1)
+				ctx.SetConnectionClose()
+			}
+		}
+
+		// modes that hijack the connection themselves and streamed bodies,
+		// which can't be written out up front, skip the half-close wait
+		if *drainDelay > 0 && !ctx.Hijacked() && !ctx.Response.IsBodyStream() {
+			// clients only half-close once told the connection is done,
+			// and fasthttp doesn't hijack connections it closes itself
+			ctx.SetConnectionClose()
+			body := ctx.Response.Body()
+			n := len(body)
+			if ctx.IsHead() {
+				body = nil
+			}
+			resp := serializeResponse(ctx, name, n, body)
+			writeRawResponse(ctx, resp, awaitHalfClose(*drainDelay))
+		}
+
+		if *regionHeader != "" {
+			if region := peekHeader(&ctx.Request.Header, *regionHeader); len(region) > 0 {
+				countRegion(region, reqSize, ctx.Response.StatusCode() >= 400)
+			}
+		}
+
+		if accessLog != nil {
+			accessLog.log(ctx, connLabel)
+		}
+		if hook != nil {
+			hook.submit(ctx, connLabel)
+		}
+
+		if *verbose {
+			label := "-"
+			if len(connLabel) > 0 {
+				label = string(connLabel)
+			}
+			log.Printf("[REQ] %s %s %s -> %d | conn=%s", ctx.RemoteAddr(), ctx.Method(), ctx.RequestURI(), ctx.Response.StatusCode(), label)
+		}
+
+		if len(healthPathSet) > 0 && healthPathSet[string(ctx.Path())] {
+			atomic.AddUint64(&totalHealthChecks, 1)
+			return
+		}
+		d := time.Since(ctx.Time())
+		requestLatency.record(d)
+		if *trackTimingPerHost {
+			if hh := hostHistogram(host, *maxTrackedHosts); hh != nil {
+				hh.record(d)
+			}
+		}
+	}
+
+	if *mode == "noop-handler" {
+		// nothing but the status code, to measure what fasthttp itself
+		// costs compared to the handler above
+		h = func(ctx *fasthttp.RequestCtx) {
+			ctx.SetStatusCode(fasthttp.StatusOK)
+		}
+	}
+
+	if flag.Arg(0) == "benchmark" {
+		runBenchmark(h, benchmarkDuration)
+		return
 	}
 
 	server := &fasthttp.Server{
 		Handler:                       h,
-		Name:                          "fast-ok-server",
+		Name:                          name,
 		ReadTimeout:                   *readTimeout,
 		WriteTimeout:                  *writeTimeout,
 		IdleTimeout:                   *idleTimeout,
+		MaxRequestsPerConn:            *maxKeepaliveRequests,
+		StreamRequestBody:             *mode == "drain-slow",
 		NoDefaultServerHeader:         true,
 		NoDefaultContentType:          true,
 		DisableHeaderNamesNormalizing: true,
@@ -113,11 +1046,53 @@ func main() {
 		LogAllErrors:                  false,
 	}
 
-	go func(interval time.Duration, top int) {
+	if *reportEvery < 1 {
+		log.Fatalf("invalid -report-interval-count %d: must be at least 1", *reportEvery)
+	}
+
+	var influx *influxExporter
+	if *influxAddr != "" {
+		if *influxMeasurement == "" {
+			log.Fatalf("-influxdb-measurement must not be empty")
+		}
+		if influx, err = newInfluxExporter(*influxAddr, *influxMeasurement); err != nil {
+			log.Fatalf("invalid -influxdb-addr %q: %v", *influxAddr, err)
+		}
+	}
+
+	statsCols, unknownCols := parseStatsCols(*statsColsSpec)
+	if len(unknownCols) > 0 {
+		log.Printf("warning: ignoring unknown -stats-cols %s (want %s)", strings.Join(unknownCols, ", "), strings.Join(statsColumns, ", "))
+	}
+
+	go func(interval time.Duration, top, every int) {
 		prevSnapshots := make(map[string]hostStats)
 		var prevTotalReq, prevTotalBytes uint64
+		var prevAccepted, prevClosed uint64
+		var prevGRPCWebMsgs, prevGRPCWebBytes uint64
+		var prevLatency []uint64
+		prevHostLatency := make(map[string][]uint64)
+		var prevMarkdownLatency []uint64
+		prevRegions := make(map[string]regionInterval)
+		idleIntervals := make(map[string]int)
+
+		window := interval * time.Duration(every)
+		perSec := func(n uint64) uint64 {
+			return uint64(float64(n) / window.Seconds())
+		}
+		var ticks int
+		var prevTickReq uint64
 
 		for range time.Tick(interval) {
+			// the stats header reports the rate of the last tick, even if
+			// only every few ticks get logged
+			tickReq := atomic.LoadUint64(&totalRequests)
+			atomic.StoreUint64(&lastIntervalRPS, uint64(float64(tickReq-prevTickReq)/interval.Seconds()))
+			prevTickReq = tickReq
+			if ticks++; ticks%every != 0 {
+				continue
+			}
+
 			currTotalReq := atomic.LoadUint64(&totalRequests)
 			currTotalBytes := atomic.LoadUint64(&totalBytes)
 			dr := currTotalReq - prevTotalReq
@@ -127,6 +1102,10 @@ func main() {
 				avg = float64(db) / float64(dr)
 			}
 
+			currAccepted := atomic.LoadUint64(&totalAccepted)
+			currClosed := atomic.LoadUint64(&totalClosed)
+			acceptErrors := atomic.LoadUint64(&totalAcceptErrors)
+
 			mg := atomic.LoadUint64(&methods.get)
 			mp := atomic.LoadUint64(&methods.post)
 			mo := atomic.LoadUint64(&methods.other)
@@ -136,6 +1115,7 @@ func main() {
 				req   uint64
 				bytes uint64
 				avg   float64
+				p99   time.Duration
 			}
 			var items []item
 
@@ -147,14 +1127,36 @@ func main() {
 				prev := prevSnapshots[h]
 				dreq := currReq - prev.requests
 				dbytes := currBytes - prev.bytes
+				p99 := time.Duration(-1) // host beyond -max-tracked-hosts
+				if v, ok := hostLatency.Load(h); ok {
+					curr := v.(*latencyHistogram).snapshot()
+					p99 = histQuantile(histDelta(curr, prevHostLatency[h]), 0.99)
+					prevHostLatency[h] = curr
+				}
 				if dreq > 0 {
 					items = append(items, item{
 						host:  h,
 						req:   dreq,
 						bytes: dbytes,
 						avg:   float64(dbytes) / float64(dreq),
+						p99:   p99,
 					})
 				}
+				if dreq > 0 {
+					delete(idleIntervals, h)
+				} else if idleIntervals[h]++; *hostEvictAfterIdle > 0 && idleIntervals[h] > *hostEvictAfterIdle {
+					// a request racing with this loses its count, but
+					// the host has been idle for a while
+					hostMap.Delete(h)
+					dropHostHistogram(h)
+					delete(prevSnapshots, h)
+					delete(prevHostLatency, h)
+					delete(idleIntervals, h)
+					if *verbose {
+						log.Printf("[EVICT] host %s idle for %d intervals", h, *hostEvictAfterIdle)
+					}
+					return true
+				}
 				prevSnapshots[h] = hostStats{requests: currReq, bytes: currBytes}
 				return true
 			})
@@ -165,50 +1167,570 @@ func main() {
 			}
 
 			uptime := time.Since(startTime).Truncate(time.Second)
-			log.Printf("total stats: req/s ~ %d | bytes/s ~ %d | avg req %.1f B | totals: %d req, %d B | methods: GET=%d POST=%d OTHER=%d | uptime=%s",
-				dr/uint64(interval.Seconds()),
-				db/uint64(interval.Seconds()),
-				avg,
-				currTotalReq,
-				currTotalBytes,
-				mg, mp, mo,
-				uptime,
-			)
+			traceID := ""
+			if *traceHeader != "" {
+				traceID = "-"
+				if id := lastTraceID.Load(); id != nil {
+					traceID = *id
+				}
+			}
+			currLatency := requestLatency.snapshot()
+			line := statsLine{
+				rps:          perSec(dr),
+				bps:          perSec(db),
+				acceptRate:   perSec(currAccepted - prevAccepted),
+				closeRate:    perSec(currClosed - prevClosed),
+				acceptErrors: acceptErrors,
+				avg:          avg,
+				totalReq:     currTotalReq,
+				totalBytes:   currTotalBytes,
+				get:          mg,
+				post:         mp,
+				other:        mo,
+				uptime:       uptime,
+				concurrent:   atomic.LoadInt64(&concurrentRequests),
+				latency:      histDelta(currLatency, prevLatency),
+				traceID:      traceID,
+			}
+			cols := statsCols
+			if len(cols) == 0 {
+				cols = defaultStatsCols
+			}
+			log.Printf("total stats: %s", line.format(cols))
+
+			if lat := histDelta(currLatency, prevLatency); dr > 0 {
+				log.Printf("latency stats: p50=%s p90=%s p99=%s p99.9=%s | health checks=%d",
+					roundLatency(histQuantile(lat, 0.50)),
+					roundLatency(histQuantile(lat, 0.90)),
+					roundLatency(histQuantile(lat, 0.99)),
+					roundLatency(histQuantile(lat, 0.999)),
+					atomic.LoadUint64(&totalHealthChecks),
+				)
+			}
+			if influx != nil {
+				lat := histDelta(currLatency, prevLatency)
+				influx.send([]influxField{
+					{"rps", perSec(dr)},
+					{"bps", perSec(db)},
+					{"requests", currTotalReq},
+					{"bytes", currTotalBytes},
+					{"concurrent", uint64(max(atomic.LoadInt64(&concurrentRequests), 0))},
+					{"accepts_per_sec", perSec(currAccepted - prevAccepted)},
+					{"closes_per_sec", perSec(currClosed - prevClosed)},
+					{"accept_errors", acceptErrors},
+					{"p50_ns", uint64(histQuantile(lat, 0.50))},
+					{"p90_ns", uint64(histQuantile(lat, 0.90))},
+					{"p99_ns", uint64(histQuantile(lat, 0.99))},
+					{"health_checks", atomic.LoadUint64(&totalHealthChecks)},
+				}, time.Now())
+			}
+			prevLatency = currLatency
+			if *histogramFile != "" {
+				if err := writeHistogramFile(*histogramFile, currLatency, time.Now()); err != nil {
+					log.Printf("histogram file error: %v", err)
+				}
+			}
 
 			if len(items) > 0 {
 				for _, it := range items {
-					log.Printf("host stats: %-40s | req/s ~ %d | avg %.1f B | interval: %d req, %d B",
+					timing := ""
+					if *trackTimingPerHost {
+						timing = " | p99=n/a"
+						if it.p99 >= 0 {
+							timing = " | p99=" + roundLatency(it.p99).String()
+						}
+					}
+					log.Printf("host stats: %-40s | req/s ~ %d | avg %.1f B | interval: %d req, %d B%s",
 						it.host,
-						it.req/uint64(interval.Seconds()),
+						perSec(it.req),
 						it.avg,
 						it.req,
 						it.bytes,
+						timing,
+					)
+				}
+			}
+
+			if *regionHeader != "" {
+				for _, it := range regionIntervals(prevRegions, top) {
+					log.Printf("region stats: %-20s | req/s ~ %d | avg %.1f B | interval: %d req, %d B, %d errors",
+						it.region,
+						perSec(it.requests),
+						float64(it.bytes)/float64(it.requests),
+						it.requests,
+						it.bytes,
+						it.errs,
+					)
+				}
+			}
+
+			if *reportMarkdown {
+				rows := make([][]string, 0, len(items)+1)
+				for _, it := range items {
+					p99 := "n/a"
+					if it.p99 >= 0 {
+						p99 = roundLatency(it.p99).String()
+					}
+					rows = append(rows, []string{
+						it.host,
+						strconv.FormatUint(perSec(it.req), 10),
+						strconv.FormatUint(perSec(it.bytes), 10),
+						strconv.FormatFloat(it.avg, 'f', 1, 64) + " B",
+						p99,
+					})
+				}
+				rows = append(rows, []string{
+					"**total**",
+					strconv.FormatUint(perSec(dr), 10),
+					strconv.FormatUint(perSec(db), 10),
+					strconv.FormatFloat(avg, 'f', 1, 64) + " B",
+					roundLatency(histQuantile(histDelta(currLatency, prevMarkdownLatency), 0.99)).String(),
+				})
+				prevMarkdownLatency = currLatency
+				fmt.Printf("\n%s", formatMarkdownTable([]string{"Host", "Req/s", "Bytes/s", "Avg Size", "p99 Latency"}, rows))
+			}
+
+			if publisher != nil {
+				publisher.publish(&statsSnapshot{
+					Time:          time.Now().UnixMilli(),
+					Instance:      instance,
+					IntervalSecs:  window.Seconds(),
+					RPS:           perSec(dr),
+					BPS:           perSec(db),
+					AvgReqBytes:   avg,
+					AcceptsPerSec: perSec(currAccepted - prevAccepted),
+					ClosesPerSec:  perSec(currClosed - prevClosed),
+					AcceptErrors:  acceptErrors,
+					Concurrent:    atomic.LoadInt64(&concurrentRequests),
+					TotalRequests: currTotalReq,
+					TotalBytes:    currTotalBytes,
+					MethodGet:     mg,
+					MethodPost:    mp,
+					MethodOther:   mo,
+					UptimeSeconds: int64(uptime.Seconds()),
+				})
+				log.Printf("multicast stats: sent=%d | send-errors=%d",
+					atomic.LoadUint64(&totalMulticastSent),
+					atomic.LoadUint64(&totalMulticastErrors),
+				)
+			}
+
+			if proxyMode != nil {
+				log.Printf("proxy stats: upstream req=%d errors=%d | injected failures=%d | headers stripped=%d injected=%d",
+					atomic.LoadUint64(&totalUpstreamRequests),
+					atomic.LoadUint64(&totalUpstreamErrors),
+					atomic.LoadUint64(&totalInjectedFailures),
+					atomic.LoadUint64(&totalHeadersStripped),
+					atomic.LoadUint64(&totalHeadersInjected),
+				)
+				for _, it := range proxyMode.intervalStats() {
+					log.Printf("upstream stats: %-40s | req/s ~ %d | errors=%d | avg latency %s | active=%d",
+						it.url,
+						perSec(it.requests),
+						it.errors,
+						it.avgLatency.Round(time.Microsecond),
+						it.active,
 					)
 				}
 			}
 
+			if *mode == "binary" {
+				log.Printf("binary stats: served=%d B", atomic.LoadUint64(&totalBinaryBytes))
+			}
+
+			if *wsSubprotocol != "" && strings.HasPrefix(*mode, "websocket-") {
+				log.Printf("websocket subprotocol stats: negotiated=%d | refused=%d | active=%d",
+					atomic.LoadUint64(&totalWSNegotiated),
+					atomic.LoadUint64(&totalWSRefused),
+					atomic.LoadInt64(&activeWSConns),
+				)
+			}
+
+			if *mode == "websocket-ping-pong" {
+				rtt := wsPingRTT.snapshot()
+				log.Printf("websocket ping stats: active=%d | pings=%d | pongs=%d | ping errors=%d | rtt p50=%s p90=%s p99=%s",
+					atomic.LoadInt64(&activeWSConns),
+					atomic.LoadUint64(&totalWSPings),
+					atomic.LoadUint64(&totalWSPongs),
+					atomic.LoadUint64(&totalWSPingErrors),
+					roundLatency(histQuantile(rtt, 0.50)),
+					roundLatency(histQuantile(rtt, 0.90)),
+					roundLatency(histQuantile(rtt, 0.99)),
+				)
+			}
+
+			if *mode == "websocket-broadcast" {
+				var avgFanout time.Duration
+				if n := atomic.LoadUint64(&totalWSBroadcasts); n > 0 {
+					avgFanout = time.Duration(atomic.LoadUint64(&totalWSFanoutNanos) / n)
+				}
+				log.Printf("websocket stats: active=%d | conns=%d | messages=%d | send-errors=%d | avg fan-out=%s",
+					atomic.LoadInt64(&activeWSConns),
+					atomic.LoadUint64(&totalWSConns),
+					atomic.LoadUint64(&totalWSMessages),
+					atomic.LoadUint64(&totalWSSendErrors),
+					roundLatency(avgFanout),
+				)
+			}
+
+			if *mode == "connection-reset-storm" {
+				log.Printf("reset storm stats: storms=%d | reset conns=%d",
+					atomic.LoadUint64(&totalStorms),
+					atomic.LoadUint64(&totalStormResets),
+				)
+			}
+
+			if *mode == "request-smuggling-detect" {
+				log.Printf("smuggling stats: suspicious requests=%d", atomic.LoadUint64(&totalSmugglingAttempts))
+			}
+
+			if *mode == "chunked-trailers" {
+				log.Printf("trailer stats: sent=%d | send-errors=%d",
+					atomic.LoadUint64(&totalTrailerResponses),
+					atomic.LoadUint64(&totalTrailerSendErrors),
+				)
+			}
+
+			if *mode == "half-open" {
+				waits := halfOpenWaits.snapshot()
+				log.Printf("half-open stats: conns=%d | open=%d | client wait p50=%s p90=%s p99=%s",
+					atomic.LoadUint64(&totalHalfOpenConns),
+					atomic.LoadInt64(&halfOpenConns),
+					roundLatency(histQuantile(waits, 0.50)),
+					roundLatency(histQuantile(waits, 0.90)),
+					roundLatency(histQuantile(waits, 0.99)),
+				)
+			}
+
+			if *mode == "reset-on-pattern" {
+				log.Printf("reset-on-pattern stats: reset=%d", atomic.LoadUint64(&totalPatternResets))
+			}
+
+			if *mode == "sleep-until" {
+				log.Printf("sleep-until stats: queued=%d | released=%d",
+					atomic.LoadInt64(&queuedRequests),
+					atomic.LoadUint64(&totalWokenRequests),
+				)
+			}
+
+			if *mode == "reject-pipeline" {
+				log.Printf("pipeline stats: rejected=%d", atomic.LoadUint64(&totalPipelinedRequests))
+			}
+
+			if *mode == "redirect-loop" {
+				log.Printf("redirect-loop stats: hops by ip: %s", formatKeyedCounts(redirectLoopCounts.top(top)))
+			}
+
+			if *mode == "sse-close" {
+				log.Printf("sse stats: streams=%d | reconnects=%d",
+					atomic.LoadUint64(&totalSSEStreams),
+					atomic.LoadUint64(&totalSSEReconnects),
+				)
+			}
+
+			if *mode == "absorb" {
+				log.Printf("absorb stats: conns=%d | bytes=%d",
+					atomic.LoadUint64(&totalAbsorbedConns),
+					atomic.LoadUint64(&totalAbsorbedBytes),
+				)
+			}
+
+			if *mode == "close-after-headers" {
+				log.Printf("close-after-headers stats: truncated=%d", atomic.LoadUint64(&totalTruncatedResponses))
+			}
+
+			if *mode == "grpc-web" {
+				currMsgs := atomic.LoadUint64(&grpcWebMessages)
+				currBytes := atomic.LoadUint64(&grpcWebBytes)
+				log.Printf("grpc-web stats: msg/s ~ %d | bytes/s ~ %d | totals: %d msg, %d B, %d malformed",
+					perSec(currMsgs-prevGRPCWebMsgs),
+					perSec(currBytes-prevGRPCWebBytes),
+					currMsgs,
+					currBytes,
+					atomic.LoadUint64(&grpcWebMalformed),
+				)
+				prevGRPCWebMsgs, prevGRPCWebBytes = currMsgs, currBytes
+				if top := grpcStatusCounts.top(len(grpcStatusCodes)); len(top) > 0 {
+					log.Printf("grpc status stats: %s", formatKeyedCounts(top))
+				}
+			}
+
+			if *mode == "partial-response" {
+				log.Printf("partial-response stats: truncated=%d", atomic.LoadUint64(&totalPartialResponses))
+			}
+
+			if *mode == "post-only" {
+				log.Printf("post-only stats: method not allowed=%d", atomic.LoadUint64(&totalMethodNotAllowed))
+			}
+
+			if *mode == "drain-slow" {
+				drained, nanos := atomic.LoadUint64(&totalDrainedBytes), atomic.LoadUint64(&totalDrainNanos)
+				var bps uint64
+				if nanos > 0 {
+					bps = uint64(float64(drained) / time.Duration(nanos).Seconds())
+				}
+				log.Printf("drain-slow stats: bodies=%d | bytes=%d | throughput ~ %d B/s | read errors=%d | too large=%d",
+					atomic.LoadUint64(&totalDrainedBodies), drained, bps, atomic.LoadUint64(&totalDrainErrors), atomic.LoadUint64(&totalDrainTooLarge))
+			}
+
+			if *mode == "echo-request-line" {
+				n := atomic.LoadUint64(&totalEchoLineResponses)
+				avg := 0.0
+				if n > 0 {
+					avg = float64(atomic.LoadUint64(&totalEchoLineBytes)) / float64(n)
+				}
+				log.Printf("echo-request-line stats: responses=%d | avg body %.1f B", n, avg)
+			}
+
+			if *mode == "upgrade-required" {
+				log.Printf("upgrade-required stats: responses=%d", atomic.LoadUint64(&totalUpgradeRequired))
+			}
+
+			if *mode == "protocol-error" {
+				log.Printf("protocol error stats: malformed responses=%d", atomic.LoadUint64(&totalProtocolErrors))
+			}
+
+			if *mode == "http-tunnel" {
+				log.Printf("tunnel stats: tunnels=%d | active=%d | rejected=%d | dial errors=%d | bytes: up=%d down=%d",
+					atomic.LoadUint64(&totalTunnels),
+					atomic.LoadInt64(&activeTunnels),
+					atomic.LoadUint64(&totalTunnelsRejected),
+					atomic.LoadUint64(&totalTunnelDialErrors),
+					atomic.LoadUint64(&totalTunnelBytesUp),
+					atomic.LoadUint64(&totalTunnelBytesDown),
+				)
+			}
+
+			if *mode == "http-200-with-connection-close" {
+				log.Printf("connection-close stats: responses=%d | keep-alive violations=%d",
+					atomic.LoadUint64(&totalConnCloseResponses),
+					atomic.LoadUint64(&totalKeepAliveViolations),
+				)
+			}
+
+			if *mode == "random-status-and-body" {
+				if top := randomStatusCounts.top(len(statusBodies)); len(top) > 0 {
+					log.Printf("status stats: %s", formatKeyedCounts(top))
+				}
+			}
+
+			if *mode == "http-upgrade" {
+				if top := upgradeCounts.top(maxUpgradeProtocols); len(top) > 0 {
+					log.Printf("upgrade stats: %s", formatKeyedCounts(top))
+				}
+			}
+
+			if tlsConfig != nil {
+				if top := tlsVersionCounts.top(len(tlsVersions)); len(top) > 0 {
+					log.Printf("tls stats: %s", formatKeyedCounts(top))
+				}
+			}
+
+			if *requestIDHeader != "" || *requestIDPropagate {
+				log.Printf("request id stats: propagated=%d | generated=%d",
+					atomic.LoadUint64(&totalRequestIdsPropagated),
+					atomic.LoadUint64(&totalRequestIdsGenerated),
+				)
+			}
+
+			if *chunked {
+				log.Printf("chunked stats: body-write-errors=%d | trailer-write-errors=%d",
+					atomic.LoadUint64(&totalBodyWriteErrors),
+					atomic.LoadUint64(&totalTrailerWriteErrors),
+				)
+			}
+
+			if *procNetStats {
+				if counts, err := tcpStateCounts(listenPort); err != nil {
+					log.Printf("tcp_states: unavailable: %v", err)
+				} else {
+					log.Printf("tcp_states: %s", formatTCPStates(counts))
+				}
+			}
+
+			if *maxURILength > 0 || *maxHeaderSize > 0 || *maxHeaderCount > 0 {
+				log.Printf("limit stats: uri-too-long=%d | header-size-exceeded=%d | header-count-exceeded=%d",
+					atomic.LoadUint64(&totalURITooLong),
+					atomic.LoadUint64(&totalHeaderSizeExceeded),
+					atomic.LoadUint64(&totalHeaderCountExceeded),
+				)
+			}
+
+			if *idleCloseAfter > 0 {
+				log.Printf("idle close stats: closed=%d", atomic.LoadUint64(&totalIdleClosed))
+			}
+
+			if gz != nil {
+				in, out := atomic.LoadUint64(&totalGzipIn), atomic.LoadUint64(&totalGzipOut)
+				ratio := 0.0
+				if in > 0 {
+					ratio = 100 * float64(out) / float64(in)
+				}
+				log.Printf("gzip stats: responses=%d | ratio=%.1f%%", atomic.LoadUint64(&totalGzipResponses), ratio)
+			}
+
+			if preGzipped {
+				log.Printf("gzip file stats: responses=%d | compressed bytes=%d",
+					atomic.LoadUint64(&totalGzipFileResponses),
+					atomic.LoadUint64(&totalGzipFileBytes),
+				)
+			}
+
+			if hook != nil {
+				log.Printf("log hook stats: runs=%d | errors=%d | dropped=%d",
+					atomic.LoadUint64(&totalLogHookRuns),
+					atomic.LoadUint64(&totalLogHookErrors),
+					atomic.LoadUint64(&totalLogHookDropped),
+				)
+			}
+
+			if *requestTimeout > 0 {
+				log.Printf("request timeout stats: timed out=%d", atomic.LoadUint64(&totalHandlerTimeouts))
+			}
+
+			if influx != nil {
+				log.Printf("influxdb stats: sent=%d | send-errors=%d",
+					atomic.LoadUint64(&totalInfluxSent),
+					atomic.LoadUint64(&totalInfluxSendErrors),
+				)
+			}
+
+			if *stickyHeader != "" {
+				log.Printf("sticky session stats: with session=%d | misrouted=%d",
+					atomic.LoadUint64(&totalStickyRequests),
+					atomic.LoadUint64(&totalMisroutedRequests),
+				)
+			}
+
+			if rateLimiter != nil {
+				util, ips := rateLimiter.utilization(time.Now())
+				log.Printf("rate limit stats: limited=%d | bursts consumed=%d | burst utilization=%.0f%% across %d IPs",
+					atomic.LoadUint64(&totalRateLimited),
+					atomic.LoadUint64(&totalBurstConsumed),
+					util*100,
+					ips,
+				)
+			}
+
+			if *methodOverrideHeader != "" {
+				log.Printf("method override stats: overrides=%d", atomic.LoadUint64(&totalMethodOverrides))
+			}
+
+			if cpuThrottle != nil {
+				log.Printf("cpu limit stats: throttled=%d | total pause=%s",
+					atomic.LoadUint64(&totalCPUThrottled),
+					time.Duration(atomic.LoadUint64(&totalCPUThrottleNanos)).Round(time.Millisecond),
+				)
+			}
+
+			if *dedupWindow > 0 {
+				log.Printf("dedup stats: duplicates=%d", atomic.LoadUint64(&totalDuplicates))
+			}
+
+			if *connLabelHeader != "" {
+				log.Printf("connection label stats: %s", formatKeyedCounts(connLabelCounts.top(top)))
+			}
+
+			if *tcpRSTAfterResponse {
+				log.Printf("tcp rst stats: reset=%d", atomic.LoadUint64(&totalRSTResponses))
+			}
+
+			if *drainDelay > 0 {
+				log.Printf("drain stats: half-closed by client=%d | timed out=%d",
+					atomic.LoadUint64(&totalHalfCloses),
+					atomic.LoadUint64(&totalHalfCloseTimeout),
+				)
+			}
+
+			if *trackQueryParams {
+				if top := queryParamCounts.top(5); len(top) > 0 {
+					log.Printf("query param stats: %s", formatKeyedCounts(top))
+				}
+			}
+
 			prevTotalReq, prevTotalBytes = currTotalReq, currTotalBytes
+			prevAccepted, prevClosed = currAccepted, currClosed
 		}
-	}(*statsEvery, *topN)
+	}(*statsEvery, *topN, *reportEvery)
 
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
 
-	ln, err := net.Listen("tcp4", *addr)
+	log.Printf("fast-ok-server starting on %s (GOMAXPROCS=%d)", *addr, runtime.GOMAXPROCS(0))
+
+	ln, err := listenWithRetry("tcp4", *addr, *bindRetryCount, *bindRetryDelay)
 	if err != nil {
 		log.Fatalf("listen error: %v", err)
 	}
 
+	if *watch {
+		go watchExecutable(*watchInterval)
+	}
+
+	if *idleCloseAfter > 0 {
+		go closeIdleConns(*idleCloseAfter)
+	}
+
+	if len(timeWindows) > 0 {
+		go runTimeWindows(timeWindows)
+	}
+
+	cl := &countingListener{Listener: ln, track: trackConns}
+	var srvLn net.Listener = cl
+	if tlsConfig != nil {
+		srvLn = tls.NewListener(srvLn, tlsConfig)
+	}
+
 	go func() {
-		if err := server.Serve(ln); err != nil {
+		// the listener is bound, so connections queue in the backlog
+		// until Serve starts accepting them
+		for left := *startupDelay; left > 0; left -= time.Second {
+			log.Printf("startup delay: accepting connections in %s", left)
+			time.Sleep(min(left, time.Second))
+		}
+		serve := server.Serve
+		if *mode == "absorb" {
+			serve = func(ln net.Listener) error {
+				return serveAbsorb(ln, *absorbMaxBytes, *absorbTimeout)
+			}
+		}
+		if err := serve(srvLn); err != nil {
 			log.Fatalf("server error: %v", err)
 		}
 	}()
 
+	// log.Fatalf exits without running deferred calls, so those exits never
+	// write the file either way.
+	cleanShutdown := false
+	if *finalStatsFile != "" {
+		defer func() {
+			if *shutdownWriteStats && !cleanShutdown {
+				log.Printf("unclean shutdown, not writing %s", *finalStatsFile)
+				return
+			}
+			if err := writeFinalStats(*finalStatsFile, instance); err != nil {
+				log.Printf("final stats error: %v", err)
+			}
+		}()
+	}
+
 	<-stop
 	log.Println("shutting down...")
+	if *drainOnShutdown {
+		cl.drain(*drainTimeout)
+	}
+	if *mode == "absorb" {
+		srvLn.Close()
+	}
 	if err := server.Shutdown(); err != nil {
 		log.Printf("shutdown error: %v", err)
+	} else {
+		cleanShutdown = true
+	}
+	if accessLog != nil {
+		if err := accessLog.close(); err != nil {
+			log.Printf("access log error: %v", err)
+		}
 	}
 
 	currReq := atomic.LoadUint64(&totalRequests)
@@ -223,4 +1745,7 @@ func main() {
 		avg,
 		time.Since(startTime).Truncate(time.Second),
 	)
+	if *mode == "request-smuggling-detect" {
+		fmt.Printf("possible request smuggling attempts: %d\n", atomic.LoadUint64(&totalSmugglingAttempts))
+	}
 }
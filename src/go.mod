@@ -2,10 +2,17 @@ module fast-ok-server
 
 go 1.24.5
 
-require github.com/valyala/fasthttp v1.65.0
+require (
+	github.com/fasthttp/websocket v1.5.12
+	github.com/valyala/fasthttp v1.65.0
+	golang.org/x/crypto v0.41.0
+	golang.org/x/text v0.28.0
+)
 
 require (
 	github.com/andybalholm/brotli v1.2.0 // indirect
 	github.com/klauspost/compress v1.18.0 // indirect
+	github.com/savsgio/gotils v0.0.0-20240704082632-aef3928b8a38 // indirect
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
+	golang.org/x/net v0.43.0 // indirect
 )
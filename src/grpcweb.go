@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	grpcWebMessages  uint64
+	grpcWebBytes     uint64
+	grpcWebMalformed uint64
+)
+
+// grpcStatusCounts counts responses per gRPC status code name.
+var grpcStatusCounts = newKeyedCounters(len(grpcStatusCodes))
+
+// grpcStatusCodes maps gRPC status code names to their numeric values.
+var grpcStatusCodes = map[string]int{
+	"OK":                  0,
+	"CANCELLED":           1,
+	"UNKNOWN":             2,
+	"INVALID_ARGUMENT":    3,
+	"DEADLINE_EXCEEDED":   4,
+	"NOT_FOUND":           5,
+	"ALREADY_EXISTS":      6,
+	"PERMISSION_DENIED":   7,
+	"RESOURCE_EXHAUSTED":  8,
+	"FAILED_PRECONDITION": 9,
+	"ABORTED":             10,
+	"OUT_OF_RANGE":        11,
+	"UNIMPLEMENTED":       12,
+	"INTERNAL":            13,
+	"UNAVAILABLE":         14,
+	"DATA_LOSS":           15,
+	"UNAUTHENTICATED":     16,
+}
+
+// parseGRPCStatusCodes parses a comma separated list of gRPC status code
+// names.
+func parseGRPCStatusCodes(s string) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if _, ok := grpcStatusCodes[name]; !ok {
+			return nil, fmt.Errorf("unknown gRPC status code %q", name)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+const (
+	grpcWebFrameHeaderLen = 5
+	grpcWebTrailerFlag    = 0x80
+)
+
+// grpcWebResponse builds a gRPC-Web response body: an empty data frame and
+// a trailer frame with the given status.
+func grpcWebResponse(status int, message string) []byte {
+	trailer := "grpc-status:" + strconv.Itoa(status) + "\r\ngrpc-message:" + message + "\r\n"
+	b := make([]byte, 0, 2*grpcWebFrameHeaderLen+len(trailer))
+	b = append(b, 0, 0, 0, 0, 0)
+	b = append(b, grpcWebTrailerFlag)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(trailer)))
+	return append(b, trailer...)
+}
+
+type grpcWebReply struct {
+	name string
+	body []byte
+}
+
+// newGRPCWebHandler returns a handler that reads the length-prefixed frames
+// of a gRPC-Web request, discards the payloads and answers with a no-op
+// response. A fraction errorRate of the calls fails with one of errorCodes,
+// picked uniformly; all others get status OK.
+func newGRPCWebHandler(errorRate float64, errorCodes []string) fasthttp.RequestHandler {
+	ok := grpcWebReply{name: "OK", body: grpcWebResponse(0, "")}
+	var errReplies []grpcWebReply
+	for _, name := range errorCodes {
+		errReplies = append(errReplies, grpcWebReply{name: name, body: grpcWebResponse(grpcStatusCodes[name], name)})
+	}
+
+	return func(ctx *fasthttp.RequestCtx) {
+		reply := ok
+		if len(errReplies) > 0 && rand.Float64() < errorRate {
+			reply = errReplies[rand.IntN(len(errReplies))]
+		}
+		respondGRPCWeb(ctx, reply)
+	}
+}
+
+func respondGRPCWeb(ctx *fasthttp.RequestCtx, reply grpcWebReply) {
+	contentType := ctx.Request.Header.ContentType()
+	if !bytes.HasPrefix(contentType, []byte("application/grpc-web")) {
+		ctx.SetStatusCode(fasthttp.StatusUnsupportedMediaType)
+		return
+	}
+	textMode := bytes.HasPrefix(contentType, []byte("application/grpc-web-text"))
+
+	body := ctx.Request.Body()
+	if textMode {
+		decoded, err := base64.StdEncoding.DecodeString(string(body))
+		if err != nil {
+			atomic.AddUint64(&grpcWebMalformed, 1)
+			ctx.SetStatusCode(fasthttp.StatusBadRequest)
+			return
+		}
+		body = decoded
+	}
+
+	var msgs, payload uint64
+	for len(body) > 0 {
+		if len(body) < grpcWebFrameHeaderLen {
+			atomic.AddUint64(&grpcWebMalformed, 1)
+			ctx.SetStatusCode(fasthttp.StatusBadRequest)
+			return
+		}
+		n := binary.BigEndian.Uint32(body[1:grpcWebFrameHeaderLen])
+		body = body[grpcWebFrameHeaderLen:]
+		if uint64(n) > uint64(len(body)) {
+			atomic.AddUint64(&grpcWebMalformed, 1)
+			ctx.SetStatusCode(fasthttp.StatusBadRequest)
+			return
+		}
+		body = body[n:]
+		msgs++
+		payload += uint64(n)
+	}
+	atomic.AddUint64(&grpcWebMessages, msgs)
+	atomic.AddUint64(&grpcWebBytes, payload)
+	grpcStatusCounts.inc([]byte(reply.name))
+
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	if textMode {
+		ctx.SetContentType("application/grpc-web-text+proto")
+		ctx.SetBodyString(base64.StdEncoding.EncodeToString(reply.body))
+		return
+	}
+	ctx.SetContentType("application/grpc-web+proto")
+	ctx.SetBody(reply.body)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	totalGzipResponses uint64
+	totalGzipIn        uint64
+	totalGzipOut       uint64
+
+	// responses and bytes served from -response-body-gzip-file
+	totalGzipFileResponses uint64
+	totalGzipFileBytes     uint64
+)
+
+// parseCompressionLevel accepts a gzip level from 1 to 9, -1 for the
+// library default, or "speed" as an alias for 1.
+func parseCompressionLevel(s string) (int, error) {
+	if s == "speed" {
+		return gzip.BestSpeed, nil
+	}
+	level, err := strconv.Atoi(s)
+	if err != nil || level != gzip.DefaultCompression && (level < gzip.BestSpeed || level > gzip.BestCompression) {
+		return 0, fmt.Errorf("want 1-9, -1 or speed")
+	}
+	return level, nil
+}
+
+// gzipper compresses response bodies at a fixed level, reusing writers.
+type gzipper struct {
+	level int
+	pool  sync.Pool
+}
+
+func newGzipper(level int) *gzipper {
+	return &gzipper{level: level}
+}
+
+func (g *gzipper) compress(body []byte) []byte {
+	var buf bytes.Buffer
+	w, _ := g.pool.Get().(*gzip.Writer)
+	if w == nil {
+		// the level is validated by parseCompressionLevel
+		w, _ = gzip.NewWriterLevel(&buf, g.level)
+	} else {
+		w.Reset(&buf)
+	}
+	w.Write(body)
+	w.Close()
+	g.pool.Put(w)
+	return buf.Bytes()
+}
+
+// acceptsGzip reports whether the request accepts gzip per Accept-Encoding.
+func acceptsGzip(h *fasthttp.RequestHeader) bool {
+	return acceptsGzipEncoding(peekHeader(h, "Accept-Encoding"))
+}
+
+// acceptsGzipEncoding reports whether an Accept-Encoding value accepts gzip,
+// either by name or through "*". A q of 0 refuses the coding, and an
+// explicit gzip entry takes precedence over "*".
+func acceptsGzipEncoding(ae []byte) bool {
+	wildcard := false
+	for len(ae) > 0 {
+		var entry []byte
+		entry, ae, _ = bytes.Cut(ae, []byte(","))
+		coding, params, _ := bytes.Cut(entry, []byte(";"))
+		coding = bytes.TrimSpace(coding)
+		switch {
+		case bytes.EqualFold(coding, []byte("gzip")):
+			return !zeroQuality(params)
+		case bytes.Equal(coding, []byte("*")):
+			wildcard = !zeroQuality(params)
+		}
+	}
+	return wildcard
+}
+
+// zeroQuality reports whether the parameters of an Accept-Encoding entry
+// contain q=0 (or 0.0, 0.00, 0.000).
+func zeroQuality(params []byte) bool {
+	for len(params) > 0 {
+		var p []byte
+		p, params, _ = bytes.Cut(params, []byte(";"))
+		k, v, ok := bytes.Cut(bytes.TrimSpace(p), []byte("="))
+		if !ok || !bytes.EqualFold(bytes.TrimSpace(k), []byte("q")) {
+			continue
+		}
+		v = bytes.TrimSpace(v)
+		if len(v) == 0 || v[0] != '0' {
+			return false
+		}
+		return len(bytes.Trim(v[1:], "0")) == 0 || (v[1] == '.' && len(bytes.Trim(v[2:], "0")) == 0)
+	}
+	return false
+}
+
+// countGzip records one compressed response of in bytes sent as out bytes.
+func countGzip(in, out int) {
+	atomic.AddUint64(&totalGzipResponses, 1)
+	atomic.AddUint64(&totalGzipIn, uint64(in))
+	atomic.AddUint64(&totalGzipOut, uint64(out))
+}
+
+// loadGzipFile reads a file that is expected to be gzip compressed already,
+// checking only its magic bytes.
+func loadGzipFile(path string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < 2 || b[0] != 0x1f || b[1] != 0x8b {
+		return nil, fmt.Errorf("%s is not gzip compressed", path)
+	}
+	return b, nil
+}
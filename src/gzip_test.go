@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestAcceptsGzipEncoding(t *testing.T) {
+	tests := []struct {
+		ae   string
+		want bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"GZIP", true},
+		{"deflate, gzip", true},
+		{"br;q=1.0, gzip;q=0.8", true},
+		{"gzip;q=0", false},
+		{"gzip; q=0.000", false},
+		{"gzip;q=0.001", true},
+		{"gzip;q=1", true},
+		{"x-gzip-foo", false},
+		{"gzipped", false},
+		{"deflate", false},
+		{"*", true},
+		{"*;q=0", false},
+		{"gzip;q=0, *", false},
+		{"*, gzip;q=0", false},
+		{"identity, *;q=0.5", true},
+	}
+	for _, tt := range tests {
+		if got := acceptsGzipEncoding([]byte(tt.ae)); got != tt.want {
+			t.Errorf("acceptsGzipEncoding(%q) = %v, want %v", tt.ae, got, tt.want)
+		}
+	}
+}
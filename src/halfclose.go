@@ -0,0 +1,36 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	totalHalfCloses       uint64
+	totalHalfCloseTimeout uint64
+)
+
+// awaitHalfClose returns a hijack handler that runs after the response has
+// been sent and waits up to delay for the client to half-close the
+// connection. fasthttp closes the server side once the handler returns.
+func awaitHalfClose(delay time.Duration) fasthttp.HijackHandler {
+	return func(c net.Conn) {
+		if err := c.SetReadDeadline(time.Now().Add(delay)); err != nil {
+			return
+		}
+		var buf [1]byte
+		_, err := c.Read(buf[:])
+		var netErr net.Error
+		switch {
+		case errors.Is(err, io.EOF):
+			atomic.AddUint64(&totalHalfCloses, 1)
+		case errors.As(err, &netErr) && netErr.Timeout():
+			atomic.AddUint64(&totalHalfCloseTimeout, 1)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	totalHalfOpenConns uint64
+	halfOpenConns      int64
+	// halfOpenWaits records how long clients waited before giving up.
+	halfOpenWaits latencyHistogram
+)
+
+// respondHalfOpen reads the request but never answers it. The connection is
+// held until the client closes it, and the time it waited is recorded.
+func respondHalfOpen(ctx *fasthttp.RequestCtx) {
+	atomic.AddUint64(&totalHalfOpenConns, 1)
+	start := ctx.Time()
+	ctx.HijackSetNoResponse(true)
+	ctx.Hijack(func(c net.Conn) {
+		atomic.AddInt64(&halfOpenConns, 1)
+		defer atomic.AddInt64(&halfOpenConns, -1)
+		// anything else the client sends is discarded; a read error or EOF
+		// means it gave up
+		io.Copy(io.Discard, c)
+		halfOpenWaits.record(time.Since(start))
+	})
+}
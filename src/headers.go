@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"math/rand/v2"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// peekHeader returns the value of the named request header. The server runs
+// with DisableHeaderNamesNormalizing, so Peek only matches the exact casing;
+// fall back to a case-insensitive scan for clients using other casings.
+func peekHeader(h *fasthttp.RequestHeader, name string) []byte {
+	if v := h.Peek(name); v != nil {
+		return v
+	}
+	for k, v := range h.All() {
+		if strings.EqualFold(string(k), name) {
+			return v
+		}
+	}
+	return nil
+}
+
+// delHeader removes all request headers matching name case-insensitively
+// and returns how many were removed.
+func delHeader(h *fasthttp.RequestHeader, name string) int {
+	var keys []string
+	for k := range h.All() {
+		if strings.EqualFold(string(k), name) {
+			keys = append(keys, string(k))
+		}
+	}
+	for _, k := range keys {
+		h.Del(k)
+	}
+	return len(keys)
+}
+
+// randomHeaderValue returns n random letters and digits, which are safe in
+// any header value.
+func randomHeaderValue(n int) []byte {
+	const chars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = chars[rand.IntN(len(chars))]
+	}
+	return b
+}
+
+var (
+	methodGet  = []byte(fasthttp.MethodGet)
+	methodPost = []byte(fasthttp.MethodPost)
+)
+
+// overrideMethod maps a method override header value, which may be in any
+// case, to the method it names. Only GET and POST are counted by name, so
+// anything else is returned as is.
+func overrideMethod(v []byte) []byte {
+	switch {
+	case bytes.EqualFold(v, methodGet):
+		return methodGet
+	case bytes.EqualFold(v, methodPost):
+		return methodPost
+	}
+	return v
+}
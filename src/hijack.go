@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net"
+
+	"github.com/valyala/fasthttp"
+)
+
+// serializeResponse returns ctx's response header followed by body, copied
+// out of fasthttp's buffers. fasthttp only fills in Content-Length and
+// Server when it writes a response itself, so they're set here first; a
+// negative contentLength leaves the header's as is.
+func serializeResponse(ctx *fasthttp.RequestCtx, serverName string, contentLength int, body []byte) []byte {
+	if contentLength >= 0 {
+		ctx.Response.Header.SetContentLength(contentLength)
+	}
+	ctx.Response.Header.SetServer(serverName)
+	hdr := ctx.Response.Header.Header()
+	resp := make([]byte, 0, len(hdr)+len(body))
+	return append(append(resp, hdr...), body...)
+}
+
+// writeRawResponse hijacks the connection to send resp instead of the
+// response fasthttp would write. fasthttp won't hand a connection with a
+// Connection: close response to a hijack handler, so modes that need to act
+// on the connection after such a response write it this way. then, if not
+// nil, runs once resp has been written; fasthttp closes the connection when
+// it returns.
+func writeRawResponse(ctx *fasthttp.RequestCtx, resp []byte, then func(c net.Conn)) {
+	ctx.HijackSetNoResponse(true)
+	ctx.Hijack(func(c net.Conn) {
+		if _, err := c.Write(resp); err != nil || then == nil {
+			return
+		}
+		then(c)
+	})
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/bits"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Each power of two is split into histSubBuckets buckets, which keeps the
+// relative error of a quantile below 25%. Durations below 2^(histMaxExp+1) ns
+// (~137s) are tracked; longer ones land in the last bucket.
+const (
+	histSubBits    = 2
+	histSubBuckets = 1 << histSubBits
+	histMaxExp     = 36
+	histBuckets    = (histMaxExp - histSubBits + 2) * histSubBuckets
+)
+
+// latencyHistogram is a lock-free histogram of durations in logarithmic
+// buckets.
+type latencyHistogram struct {
+	counts [histBuckets]uint64
+}
+
+var requestLatency latencyHistogram
+
+// hostLatency holds a *latencyHistogram per host with -track-timing-per-host.
+var (
+	hostLatency      sync.Map
+	hostLatencyCount int64
+)
+
+// hostHistogram returns the histogram for host, creating it unless max
+// hosts are tracked already, in which case it returns nil.
+func hostHistogram(host string, max int) *latencyHistogram {
+	if v, ok := hostLatency.Load(host); ok {
+		return v.(*latencyHistogram)
+	}
+	if atomic.LoadInt64(&hostLatencyCount) >= int64(max) {
+		return nil
+	}
+	v, loaded := hostLatency.LoadOrStore(host, new(latencyHistogram))
+	if !loaded {
+		atomic.AddInt64(&hostLatencyCount, 1)
+	}
+	return v.(*latencyHistogram)
+}
+
+// dropHostHistogram forgets host's histogram, freeing its slot.
+func dropHostHistogram(host string) {
+	if _, loaded := hostLatency.LoadAndDelete(host); loaded {
+		atomic.AddInt64(&hostLatencyCount, -1)
+	}
+}
+
+func histBucket(ns uint64) int {
+	if ns < histSubBuckets {
+		return int(ns)
+	}
+	exp := bits.Len64(ns) - 1
+	sub := int(ns>>(exp-histSubBits)) & (histSubBuckets - 1)
+	i := (exp-histSubBits+1)*histSubBuckets + sub
+	if i >= histBuckets {
+		return histBuckets - 1
+	}
+	return i
+}
+
+// histUpperBound returns the exclusive upper bound of bucket i in ns.
+func histUpperBound(i int) uint64 {
+	if i < histSubBuckets {
+		return uint64(i) + 1
+	}
+	exp := i/histSubBuckets + histSubBits - 1
+	sub := uint64(i % histSubBuckets)
+	return (histSubBuckets + sub + 1) << (exp - histSubBits)
+}
+
+func (h *latencyHistogram) record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	atomic.AddUint64(&h.counts[histBucket(uint64(d))], 1)
+}
+
+// snapshot returns the current bucket counts.
+func (h *latencyHistogram) snapshot() []uint64 {
+	counts := make([]uint64, histBuckets)
+	for i := range counts {
+		counts[i] = atomic.LoadUint64(&h.counts[i])
+	}
+	return counts
+}
+
+// histDelta returns curr-prev per bucket.
+func histDelta(curr, prev []uint64) []uint64 {
+	d := make([]uint64, len(curr))
+	for i := range curr {
+		d[i] = curr[i]
+		if prev != nil {
+			d[i] -= prev[i]
+		}
+	}
+	return d
+}
+
+// histQuantile returns the upper bound of the bucket holding quantile q of
+// counts, or 0 if counts is empty.
+func histQuantile(counts []uint64, q float64) time.Duration {
+	var total uint64
+	for _, c := range counts {
+		total += c
+	}
+	if total == 0 {
+		return 0
+	}
+	rank := uint64(q * float64(total))
+	if rank >= total {
+		rank = total - 1
+	}
+	var seen uint64
+	for i, c := range counts {
+		seen += c
+		if seen > rank {
+			return time.Duration(histUpperBound(i))
+		}
+	}
+	return time.Duration(histUpperBound(len(counts) - 1))
+}
+
+// roundLatency trims a duration to a readable precision for logging.
+func roundLatency(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(time.Microsecond)
+	default:
+		return d
+	}
+}
+
+// Histogram file layout, all little-endian: magic, version, epoch (unix
+// seconds), bucket count, then per bucket its exclusive upper bound in ns
+// and its count.
+const (
+	histFileMagic   = "FOKH"
+	histFileVersion = 1
+)
+
+// writeHistogramFile writes counts to path, replacing the file atomically so
+// readers never see a partial histogram.
+func writeHistogramFile(path string, counts []uint64, t time.Time) error {
+	var buf bytes.Buffer
+	buf.Grow(24 + 16*len(counts))
+	buf.WriteString(histFileMagic)
+	binary.Write(&buf, binary.LittleEndian, uint32(histFileVersion))
+	binary.Write(&buf, binary.LittleEndian, t.Unix())
+	binary.Write(&buf, binary.LittleEndian, uint64(len(counts)))
+	for i, c := range counts {
+		binary.Write(&buf, binary.LittleEndian, [2]uint64{histUpperBound(i), c})
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	totalInfluxSent       uint64
+	totalInfluxSendErrors uint64
+)
+
+// influxMeasurementEscaper escapes the characters that are special in an
+// InfluxDB line protocol measurement name.
+var influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+
+type influxField struct {
+	key   string
+	value uint64
+}
+
+// influxExporter sends stats to InfluxDB as line protocol over UDP, one
+// datagram per stats interval.
+type influxExporter struct {
+	conn        net.Conn
+	measurement string
+	buf         []byte
+}
+
+func newInfluxExporter(addr, measurement string) (*influxExporter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &influxExporter{conn: conn, measurement: influxMeasurementEscaper.Replace(measurement)}, nil
+}
+
+// send writes one point with fields timestamped at t. UDP gives no delivery
+// guarantee, so only local send failures are counted.
+func (e *influxExporter) send(fields []influxField, t time.Time) {
+	b := append(e.buf[:0], e.measurement...)
+	for i, f := range fields {
+		if i == 0 {
+			b = append(b, ' ')
+		} else {
+			b = append(b, ',')
+		}
+		b = append(b, f.key...)
+		b = append(b, '=')
+		b = strconv.AppendUint(b, f.value, 10)
+	}
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, t.UnixNano(), 10)
+	b = append(b, '\n')
+	e.buf = b
+
+	if _, err := e.conn.Write(b); err != nil {
+		atomic.AddUint64(&totalInfluxSendErrors, 1)
+		return
+	}
+	atomic.AddUint64(&totalInfluxSent, 1)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseLatencyDistribution builds a sampler from a -backend-latency-simulation
+// spec. Supported forms:
+//
+//	normal:mean_ms:stddev_ms
+//	lognormal:mean_ms:sigma
+//	bimodal:mean1_ms:mean2_ms:pct1
+//
+// For bimodal, pct1 percent of samples are drawn around mean1 and the rest
+// around mean2, each with a stddev of 10% of its mean.
+func parseLatencyDistribution(spec string) (func() time.Duration, error) {
+	parts := strings.Split(spec, ":")
+	params := make([]float64, 0, len(parts)-1)
+	for _, p := range parts[1:] {
+		f, err := strconv.ParseFloat(p, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid parameter %q: %v", p, err)
+		}
+		if f < 0 {
+			return nil, fmt.Errorf("parameter %q must not be negative", p)
+		}
+		params = append(params, f)
+	}
+
+	switch parts[0] {
+	case "normal":
+		if len(params) != 2 {
+			return nil, fmt.Errorf("want normal:mean_ms:stddev_ms")
+		}
+		mean, stddev := params[0], params[1]
+		return func() time.Duration {
+			return msToDuration(mean + stddev*rand.NormFloat64())
+		}, nil
+	case "lognormal":
+		if len(params) != 2 || params[0] == 0 {
+			return nil, fmt.Errorf("want lognormal:mean_ms:sigma with mean_ms > 0")
+		}
+		sigma := params[1]
+		// pick mu so that the distribution's mean is mean_ms
+		mu := math.Log(params[0]) - sigma*sigma/2
+		return func() time.Duration {
+			return msToDuration(math.Exp(mu + sigma*rand.NormFloat64()))
+		}, nil
+	case "bimodal":
+		if len(params) != 3 || params[2] > 100 {
+			return nil, fmt.Errorf("want bimodal:mean1_ms:mean2_ms:pct1 with pct1 in 0-100")
+		}
+		mean1, mean2, pct1 := params[0], params[1], params[2]
+		return func() time.Duration {
+			mean := mean2
+			if rand.Float64()*100 < pct1 {
+				mean = mean1
+			}
+			return msToDuration(mean + 0.1*mean*rand.NormFloat64())
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown distribution %q (want normal, lognormal or bimodal)", parts[0])
+	}
+}
+
+// msToDuration converts a sample in milliseconds to a duration, clamping
+// negative samples to zero.
+func msToDuration(ms float64) time.Duration {
+	if ms <= 0 {
+		return 0
+	}
+	return time.Duration(ms * float64(time.Millisecond))
+}
+
+// paretoSampler draws from a Pareto distribution with the given shape and
+// scale (its minimum) in ms by inverting the CDF. Shapes at or below 1 have
+// no finite mean, so expect occasional very long delays.
+func paretoSampler(shape, scaleMs float64) (func() time.Duration, error) {
+	if shape <= 0 || scaleMs <= 0 {
+		return nil, fmt.Errorf("shape and scale must be positive")
+	}
+	return func() time.Duration {
+		// 1-Float64 is in (0, 1], keeping the power finite
+		return msToDuration(scaleMs / math.Pow(1-rand.Float64(), 1/shape))
+	}, nil
+}
@@ -0,0 +1,18 @@
+package main
+
+import "github.com/valyala/fasthttp"
+
+var (
+	totalHeaderCountExceeded uint64
+	totalHeaderSizeExceeded  uint64
+	totalURITooLong          uint64
+)
+
+// headerCount returns the number of request headers.
+func headerCount(h *fasthttp.RequestHeader) int {
+	n := 0
+	for range h.All() {
+		n++
+	}
+	return n
+}
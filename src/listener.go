@@ -0,0 +1,208 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+var (
+	totalAccepted     uint64
+	totalClosed       uint64
+	totalAcceptErrors uint64
+	totalIdleClosed   uint64
+	totalRSTResponses uint64
+)
+
+// maxConnLabels caps the number of distinct connection labels tracked.
+const maxConnLabels = 100
+
+// connLabelCounts counts requests per -connection-label-header label.
+var connLabelCounts = newKeyedCounters(maxConnLabels)
+
+// activeConns holds every open *countingConn while connection tracking is
+// enabled on the listener.
+var activeConns sync.Map
+
+// countingListener wraps a net.Listener and counts accepted connections,
+// accept errors and (via countingConn) closed connections. With track set,
+// open connections are also registered in activeConns.
+type countingListener struct {
+	net.Listener
+	track bool
+}
+
+// draining is set by countingListener.drain. It makes Accept close new
+// connections right away, while the listener itself stays open for
+// fasthttp's Serve, and the handler ask clients to close their keep-alive
+// connections after the response.
+var draining int32
+
+// drain refuses new connections and waits until no tracked connection is
+// mid-request and no handler is running, or until timeout has passed.
+func (l *countingListener) drain(timeout time.Duration) {
+	atomic.StoreInt32(&draining, 1)
+	deadline := time.Now().Add(timeout)
+	for {
+		pending, active := pendingConns(), atomic.LoadInt64(&concurrentRequests)
+		if pending == 0 && active == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			log.Printf("drain timed out with %d connections still sending and %d requests in flight", pending, active)
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	for err == nil && atomic.LoadInt32(&draining) == 1 {
+		c.Close()
+		c, err = l.Listener.Accept()
+	}
+	if err != nil {
+		// closing the listener on shutdown isn't an accept failure
+		if !errors.Is(err, net.ErrClosed) {
+			atomic.AddUint64(&totalAcceptErrors, 1)
+		}
+		return nil, err
+	}
+	atomic.AddUint64(&totalAccepted, 1)
+	cc := &countingConn{Conn: c, tracked: l.track, lastActivityNano: time.Now().UnixNano()}
+	if l.track {
+		activeConns.Store(cc, struct{}{})
+	}
+	return cc, nil
+}
+
+// countingConn counts a connection as closed exactly once, no matter how
+// often Close is called on it.
+type countingConn struct {
+	net.Conn
+	closeOnce        sync.Once
+	tracked          bool
+	lastActivityNano int64
+	lastReadNano     int64
+	inFlight         int32
+
+	// These are only accessed by the goroutine serving the connection's
+	// requests: label is set from -connection-label-header on the first
+	// request, stormEpoch by -mode connection-reset-storm, and the rest are
+	// used by -mode reject-pipeline.
+	label      []byte
+	stormEpoch uint64
+	bytesRead  uint64
+	consumed   uint64
+	pipelined  bool
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 && c.tracked {
+		c.bytesRead += uint64(n)
+		atomic.StoreInt64(&c.lastReadNano, time.Now().UnixNano())
+	}
+	return n, err
+}
+
+func (c *countingConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() {
+		atomic.AddUint64(&totalClosed, 1)
+		if c.tracked {
+			activeConns.Delete(c)
+		}
+	})
+	return err
+}
+
+// countingConnOf returns the countingConn behind c, looking through TLS,
+// or nil if c wasn't accepted by a countingListener.
+func countingConnOf(c net.Conn) *countingConn {
+	if tc, ok := c.(*tls.Conn); ok {
+		c = tc.NetConn()
+	}
+	cc, _ := c.(*countingConn)
+	return cc
+}
+
+// touch records request activity on the connection. It's called once the
+// request has been read, so a later read means the next one is underway.
+func (c *countingConn) touch() {
+	atomic.StoreInt32(&c.inFlight, 1)
+	atomic.StoreInt64(&c.lastActivityNano, time.Now().UnixNano())
+}
+
+// finish records the end of the request started by touch, so the idle time
+// of a connection counts from its last response rather than its last request.
+func (c *countingConn) finish() {
+	atomic.StoreInt64(&c.lastActivityNano, time.Now().UnixNano())
+	atomic.StoreInt32(&c.inFlight, 0)
+}
+
+// resetOnClose sets SO_LINGER to 0 so that closing the connection sends a
+// RST instead of a FIN. Data still queued in the send buffer at that point
+// is discarded.
+func (c *countingConn) resetOnClose() error {
+	tc, ok := c.Conn.(*net.TCPConn)
+	if !ok {
+		return errors.New("not a TCP connection")
+	}
+	return tc.SetLinger(0)
+}
+
+// closeIdleConns closes tracked connections without request activity for
+// longer than after, checking every after/4. Connections with a request
+// still in the handler are never idle, however long it takes.
+func closeIdleConns(after time.Duration) {
+	for range time.Tick(after / 4) {
+		cutoff := time.Now().Add(-after).UnixNano()
+		activeConns.Range(func(k, _ any) bool {
+			c := k.(*countingConn)
+			if atomic.LoadInt32(&c.inFlight) == 0 && atomic.LoadInt64(&c.lastActivityNano) < cutoff {
+				atomic.AddUint64(&totalIdleClosed, 1)
+				c.Close()
+			}
+			return true
+		})
+	}
+}
+
+// pendingConns counts tracked connections that have received data since
+// their last request reached the handler, i.e. are in the middle of sending
+// a request.
+func pendingConns() int {
+	n := 0
+	activeConns.Range(func(k, _ any) bool {
+		c := k.(*countingConn)
+		if atomic.LoadInt64(&c.lastReadNano) > atomic.LoadInt64(&c.lastActivityNano) {
+			n++
+		}
+		return true
+	})
+	return n
+}
+
+// listenWithRetry calls net.Listen, retrying up to retries times with delay
+// in between while the address is still in use or not yet available.
+func listenWithRetry(network, addr string, retries int, delay time.Duration) (net.Listener, error) {
+	for attempt := 1; ; attempt++ {
+		ln, err := net.Listen(network, addr)
+		if err == nil {
+			return ln, nil
+		}
+		transient := errors.Is(err, syscall.EADDRINUSE) || errors.Is(err, syscall.EADDRNOTAVAIL)
+		if !transient || attempt > retries {
+			return nil, err
+		}
+		log.Printf("listen error: %v, retrying in %s (%d/%d)", err, delay, attempt, retries)
+		time.Sleep(delay)
+	}
+}
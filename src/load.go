@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// loadCallsPerSecond is the per-IP rate limit of the load endpoint.
+const loadCallsPerSecond = 10
+
+type loadReport struct {
+	ConcurrentRequests int64   `json:"concurrent_requests"`
+	RPSLastInterval    uint64  `json:"rps_last_interval"`
+	TotalRequests      uint64  `json:"total_requests"`
+	UptimeSeconds      int64   `json:"uptime_seconds"`
+	MemAllocMB         float64 `json:"mem_alloc_mb"`
+}
+
+// ipRateLimiter allows up to limit calls per IP within each wall clock
+// second. The counts are dropped every second, so the map only ever holds
+// the IPs seen in the current one.
+type ipRateLimiter struct {
+	limit  int
+	mu     sync.Mutex
+	sec    int64
+	counts map[string]int
+}
+
+func (l *ipRateLimiter) allow(ip string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if sec := now.Unix(); sec != l.sec || l.counts == nil {
+		l.sec = sec
+		l.counts = make(map[string]int)
+	}
+	l.counts[ip]++
+	return l.counts[ip] <= l.limit
+}
+
+// newLoadHandler serves current load metrics as JSON.
+func newLoadHandler() fasthttp.RequestHandler {
+	limiter := &ipRateLimiter{limit: loadCallsPerSecond}
+	return func(ctx *fasthttp.RequestCtx) {
+		if !limiter.allow(ctx.RemoteIP().String(), ctx.Time()) {
+			ctx.SetStatusCode(fasthttp.StatusTooManyRequests)
+			ctx.SetBodyString("too many requests")
+			return
+		}
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		b, _ := json.Marshal(&loadReport{
+			ConcurrentRequests: atomic.LoadInt64(&concurrentRequests),
+			RPSLastInterval:    atomic.LoadUint64(&lastIntervalRPS),
+			TotalRequests:      atomic.LoadUint64(&totalRequests),
+			UptimeSeconds:      int64(time.Since(startTime).Seconds()),
+			MemAllocMB:         float64(ms.Alloc) / (1 << 20),
+		})
+		ctx.SetContentType("application/json")
+		ctx.SetBody(b)
+	}
+}
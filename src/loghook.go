@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"sync/atomic"
+
+	"github.com/valyala/fasthttp"
+)
+
+// logHookQueueSize bounds the requests waiting for a hook worker; beyond it
+// summaries are dropped rather than slowing down the handler.
+const logHookQueueSize = 1024
+
+var (
+	totalLogHookRuns    uint64
+	totalLogHookErrors  uint64
+	totalLogHookDropped uint64
+)
+
+// logHook runs a shell command for every sample-th request, with the
+// request's access log entry as a JSON line on stdin. The command's output
+// goes to the server's stdout and stderr.
+type logHook struct {
+	cmd    string
+	sample uint64
+	n      uint64
+	queue  chan []byte
+}
+
+func newLogHook(cmd string, sample, workers int) *logHook {
+	h := &logHook{cmd: cmd, sample: uint64(sample), queue: make(chan []byte, logHookQueueSize)}
+	for range workers {
+		go h.work()
+	}
+	return h
+}
+
+func (h *logHook) submit(ctx *fasthttp.RequestCtx, connLabel []byte) {
+	if atomic.AddUint64(&h.n, 1)%h.sample != 0 {
+		return
+	}
+	e := newAccessLogEntry(ctx, connLabel)
+	b, err := json.Marshal(&e)
+	if err != nil {
+		atomic.AddUint64(&totalLogHookErrors, 1)
+		return
+	}
+	select {
+	case h.queue <- append(b, '\n'):
+	default:
+		atomic.AddUint64(&totalLogHookDropped, 1)
+	}
+}
+
+func (h *logHook) work() {
+	for line := range h.queue {
+		c := exec.Command("/bin/sh", "-c", h.cmd)
+		c.Stdin = bytes.NewReader(line)
+		c.Stdout, c.Stderr = os.Stdout, os.Stderr
+		atomic.AddUint64(&totalLogHookRuns, 1)
+		if err := c.Run(); err != nil {
+			atomic.AddUint64(&totalLogHookErrors, 1)
+		}
+	}
+}
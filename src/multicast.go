@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync/atomic"
+)
+
+var (
+	totalMulticastSent   uint64
+	totalMulticastErrors uint64
+)
+
+// multicastPublisher sends stats snapshots as JSON datagrams to a UDP
+// multicast group. Sending to a group doesn't require joining it, so a
+// plain UDP socket is used; receivers join with net.ListenMulticastUDP.
+type multicastPublisher struct {
+	conn *net.UDPConn
+}
+
+func newMulticastPublisher(addr string) (*multicastPublisher, error) {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if !raddr.IP.IsMulticast() {
+		return nil, fmt.Errorf("%s is not a multicast address", raddr.IP)
+	}
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+	return &multicastPublisher{conn: conn}, nil
+}
+
+func (p *multicastPublisher) publish(s *statsSnapshot) {
+	b, err := json.Marshal(s)
+	if err == nil {
+		_, err = p.conn.Write(b)
+	}
+	if err != nil {
+		atomic.AddUint64(&totalMulticastErrors, 1)
+		return
+	}
+	atomic.AddUint64(&totalMulticastSent, 1)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"golang.org/x/crypto/ocsp"
+)
+
+// ocspStapler fetches OCSP responses for the server certificate and serves
+// the certificate with the current response stapled.
+type ocspStapler struct {
+	leaf   *x509.Certificate
+	issuer *x509.Certificate
+	base   tls.Certificate
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+// newOCSPStapler needs the certificate chain to include the issuer directly
+// after the leaf, since the OCSP request is built from both.
+func newOCSPStapler(cert tls.Certificate) (*ocspStapler, error) {
+	if len(cert.Certificate) < 2 {
+		return nil, fmt.Errorf("certificate file must contain the issuer certificate after the leaf")
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	issuer, err := x509.ParseCertificate(cert.Certificate[1])
+	if err != nil {
+		return nil, err
+	}
+	if len(leaf.OCSPServer) == 0 {
+		return nil, fmt.Errorf("certificate has no OCSP responder URL")
+	}
+	return &ocspStapler{leaf: leaf, issuer: issuer, base: cert, cert: &cert}, nil
+}
+
+// refresh fetches a fresh OCSP response and staples it, returning the parsed
+// response.
+func (s *ocspStapler) refresh() (*ocsp.Response, error) {
+	reqBody, err := ocsp.CreateRequest(s.leaf, s.issuer, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+	req.SetRequestURI(s.leaf.OCSPServer[0])
+	req.Header.SetMethod(fasthttp.MethodPost)
+	req.Header.SetContentType("application/ocsp-request")
+	req.SetBody(reqBody)
+	if err := fasthttp.DoTimeout(req, resp, 10*time.Second); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode() != fasthttp.StatusOK {
+		return nil, fmt.Errorf("OCSP responder returned status %d", resp.StatusCode())
+	}
+
+	raw := append([]byte(nil), resp.Body()...)
+	parsed, err := ocsp.ParseResponseForCert(raw, s.leaf, s.issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	cert := s.base
+	cert.OCSPStaple = raw
+	s.mu.Lock()
+	s.cert = &cert
+	s.mu.Unlock()
+	return parsed, nil
+}
+
+func (s *ocspStapler) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cert, nil
+}
+
+// run keeps the staple fresh, refreshing at the time returned by
+// ocspRefreshTime and retrying every minute on errors.
+func (s *ocspStapler) run(next time.Time) {
+	for {
+		time.Sleep(time.Until(next))
+		resp, err := s.refresh()
+		if err != nil {
+			log.Printf("ocsp refresh error: %v", err)
+			next = time.Now().Add(time.Minute)
+			continue
+		}
+		next = ocspRefreshTime(resp, time.Now())
+	}
+}
+
+// ocspRefreshTime picks a refresh time three quarters into the response's
+// validity window, or an hour from now if the responder gives no NextUpdate.
+// It's never less than a minute away, so a responder handing out stale
+// responses isn't asked again in a tight loop.
+func ocspRefreshTime(resp *ocsp.Response, now time.Time) time.Time {
+	if resp.NextUpdate.IsZero() {
+		return now.Add(time.Hour)
+	}
+	next := resp.ThisUpdate.Add(resp.NextUpdate.Sub(resp.ThisUpdate) * 3 / 4)
+	if earliest := now.Add(time.Minute); next.Before(earliest) {
+		return earliest
+	}
+	return next
+}
+
+func ocspStatusName(status int) string {
+	switch status {
+	case ocsp.Good:
+		return "good"
+	case ocsp.Revoked:
+		return "revoked"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+func TestOCSPRefreshTime(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		thisUpdate time.Time
+		nextUpdate time.Time
+		want       time.Time
+	}{
+		{"no next update", now.Add(-time.Hour), time.Time{}, now.Add(time.Hour)},
+		{"fresh", now, now.Add(4 * time.Hour), now.Add(3 * time.Hour)},
+		{"three quarters passed", now.Add(-6 * time.Hour), now.Add(2 * time.Hour), now.Add(time.Minute)},
+		{"expired", now.Add(-48 * time.Hour), now.Add(-24 * time.Hour), now.Add(time.Minute)},
+		{"just over a minute left", now.Add(-3 * time.Minute), now.Add(5 * time.Minute), now.Add(3 * time.Minute)},
+	}
+	for _, tt := range tests {
+		resp := &ocsp.Response{ThisUpdate: tt.thisUpdate, NextUpdate: tt.nextUpdate}
+		if got := ocspRefreshTime(resp, now); !got.Equal(tt.want) {
+			t.Errorf("%s: ocspRefreshTime = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"sync/atomic"
+
+	"github.com/valyala/fasthttp"
+)
+
+var totalPipelinedRequests uint64
+
+// requestWireSize returns the number of bytes req took on the connection.
+// It reports false for chunked bodies, whose encoded size isn't kept.
+//
+// The headers are counted from the raw bytes fasthttp read rather than its
+// re-serialized header, which folds whitespace, and adds defaults such as
+// Content-Type. The request line is rebuilt, so it assumes single spaces
+// and a CRLF ending.
+func requestWireSize(req *fasthttp.Request) (uint64, bool) {
+	h := &req.Header
+	if h.ContentLength() == -1 {
+		return 0, false
+	}
+	// request line: METHOD SP URI SP PROTO CRLF
+	n := len(h.Method()) + 1 + len(h.RequestURI()) + 1 + len(h.Protocol()) + 2
+	if raw := h.RawHeaders(); len(raw) > 0 {
+		// includes the blank line ending the headers
+		n += len(raw)
+	} else {
+		n += 2
+	}
+	return uint64(n + len(req.Body())), true
+}
+
+// newRejectPipelineHandler serves requests with next unless the client has
+// pipelined them. A connection on which more bytes had been read than the
+// requests so far account for had the next request sent before its
+// response; that request gets a 400 and the connection is closed. Only
+// plain-text connections are checked, since TLS framing adds to the bytes
+// read.
+func newRejectPipelineHandler(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		c := countingConnOf(ctx.Conn())
+		if c == nil || ctx.IsTLS() {
+			next(ctx)
+			return
+		}
+		if c.pipelined {
+			atomic.AddUint64(&totalPipelinedRequests, 1)
+			ctx.SetStatusCode(fasthttp.StatusBadRequest)
+			ctx.SetBodyString("pipelined request rejected")
+			ctx.SetConnectionClose()
+			return
+		}
+		if n, ok := requestWireSize(&ctx.Request); ok {
+			c.consumed += n
+			c.pipelined = c.bytesRead > c.consumed
+		} else {
+			c.consumed = c.bytesRead
+		}
+		next(ctx)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestRequestWireSize(t *testing.T) {
+	tests := []struct {
+		name   string
+		raw    string
+		wantOK bool
+	}{
+		{"no headers", "GET / HTTP/1.1\r\n\r\n", true},
+		{"host only", "GET /x?y=1 HTTP/1.1\r\nHost: example.com\r\n\r\n", true},
+		{"http/1.0", "GET / HTTP/1.0\r\n\r\n", true},
+		{"http/1.0 keep-alive", "GET / HTTP/1.0\r\nHost: h\r\nConnection: keep-alive\r\n\r\n", true},
+		{"http/1.0 close", "GET / HTTP/1.0\r\nConnection: close\r\n\r\n", true},
+		{"several headers", "GET /a HTTP/1.1\r\nHost: h\r\nUser-Agent: test\r\nAccept: */*\r\nX-Custom: v\r\n\r\n", true},
+		{"body", "POST /p HTTP/1.1\r\nHost: h\r\nContent-Type: text/plain\r\nContent-Length: 5\r\n\r\nhello", true},
+		{"duplicate headers", "GET / HTTP/1.1\r\nHost: h\r\nUser-Agent: a\r\nUser-Agent: b\r\n\r\n", true},
+		{"extra whitespace", "GET / HTTP/1.1\r\nHost:  h\r\n\r\n", true},
+		{"no space after colon", "GET / HTTP/1.1\r\nHost:h\r\nX-A:1\r\n\r\n", true},
+		{"bodiless post", "POST /submit HTTP/1.1\r\nHost: h\r\nContent-Length: 0\r\n\r\n", true},
+		{"chunked", "POST /p HTTP/1.1\r\nHost: h\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n", false},
+	}
+	for _, tt := range tests {
+		var req fasthttp.Request
+		req.Header.DisableNormalizing()
+		if err := req.Read(bufio.NewReader(strings.NewReader(tt.raw))); err != nil {
+			t.Fatalf("%s: reading request: %v", tt.name, err)
+		}
+		n, ok := requestWireSize(&req)
+		if ok != tt.wantOK {
+			t.Errorf("%s: requestWireSize ok = %v, want %v", tt.name, ok, tt.wantOK)
+			continue
+		}
+		if ok && n != uint64(len(tt.raw)) {
+			t.Errorf("%s: requestWireSize = %d, want %d", tt.name, n, len(tt.raw))
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"sync/atomic"
+
+	"github.com/valyala/fasthttp"
+)
+
+// totalMethodNotAllowed counts requests refused with 405 because of their
+// method.
+var totalMethodNotAllowed uint64
+
+var methodNotAllowedBody = []byte(`{"error": "method_not_allowed"}`)
+
+// newPostOnlyHandler refuses everything but POST with a JSON 405 and hands
+// POST requests to next.
+func newPostOnlyHandler(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		if ctx.IsPost() {
+			next(ctx)
+			return
+		}
+		atomic.AddUint64(&totalMethodNotAllowed, 1)
+		ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
+		ctx.Response.Header.Set("Allow", fasthttp.MethodPost)
+		ctx.SetContentType("application/json")
+		ctx.SetBody(methodNotAllowedBody)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// tcpStateNames maps the hex state column of /proc/net/tcp to short names,
+// in the order they are printed.
+var tcpStateNames = []struct {
+	code string
+	name string
+}{
+	{"01", "EST"},
+	{"02", "SS"},
+	{"03", "SR"},
+	{"04", "FW1"},
+	{"05", "FW2"},
+	{"06", "TW"},
+	{"07", "CL"},
+	{"08", "CW"},
+	{"09", "LA"},
+	{"0A", "LSN"},
+	{"0B", "CLG"},
+}
+
+var procNetFiles = []string{"/proc/net/tcp", "/proc/net/tcp6"}
+
+// tcpStateCounts counts the sockets with the given local port per TCP state,
+// reading /proc/net/tcp and /proc/net/tcp6. It fails only if neither file
+// can be read.
+func tcpStateCounts(port int) (map[string]int, error) {
+	counts := make(map[string]int)
+	hexPort := ":" + strings.ToUpper(strconv.FormatInt(int64(port), 16))
+	for len(hexPort) < 5 {
+		hexPort = ":0" + hexPort[1:]
+	}
+
+	var lastErr error
+	read := 0
+	for _, path := range procNetFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		read++
+		sc := bufio.NewScanner(bytes.NewReader(data))
+		sc.Scan() // header line
+		for sc.Scan() {
+			// sl local_address rem_address st ...
+			fields := strings.Fields(sc.Text())
+			if len(fields) < 4 || !strings.HasSuffix(fields[1], hexPort) {
+				continue
+			}
+			counts[fields[3]]++
+		}
+	}
+	if read == 0 {
+		return nil, lastErr
+	}
+	return counts, nil
+}
+
+// formatTCPStates renders non-zero state counts as NAME=N pairs.
+func formatTCPStates(counts map[string]int) string {
+	var parts []string
+	for _, s := range tcpStateNames {
+		if n := counts[s.code]; n > 0 {
+			parts = append(parts, s.name+"="+strconv.Itoa(n))
+		}
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"math/rand/v2"
+	"net"
+	"sync/atomic"
+
+	"github.com/valyala/fasthttp"
+)
+
+var totalProtocolErrors uint64
+
+// malformedResponses are the broken responses -mode protocol-error picks
+// from. The connection is closed after each, so a client can't mistake
+// leftover bytes for the next response.
+var malformedResponses = [][]byte{
+	// no CRLF after the status line
+	[]byte("HTTP/1.1 200 OKContent-Type: text/plain\r\nContent-Length: 2\r\n\r\nOK"),
+	// Content-Length that isn't a number
+	[]byte("HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: two\r\n\r\nOK"),
+	// Content-Length longer than the body that follows
+	[]byte("HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 100\r\n\r\nOK"),
+	// header block that never ends
+	[]byte("HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Len"),
+	// header line without a colon
+	[]byte("HTTP/1.1 200 OK\r\nContent-Type text/plain\r\nContent-Length: 2\r\n\r\nOK"),
+	// status code that isn't three digits
+	[]byte("HTTP/1.1 2000 OK\r\nContent-Length: 2\r\n\r\nOK"),
+	// bare LF line endings
+	[]byte("HTTP/1.1 200 OK\nContent-Length: 2\n\nOK"),
+}
+
+// newProtocolErrorHandler answers rate of requests with one of
+// malformedResponses and closes the connection; the rest go to next.
+func newProtocolErrorHandler(rate float64, next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		if rand.Float64() >= rate {
+			next(ctx)
+			return
+		}
+		resp := malformedResponses[rand.IntN(len(malformedResponses))]
+		ctx.HijackSetNoResponse(true)
+		// returning from the hijack handler closes the connection
+		ctx.Hijack(func(c net.Conn) {
+			if _, err := c.Write(resp); err == nil {
+				atomic.AddUint64(&totalProtocolErrors, 1)
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	totalUpstreamRequests uint64
+	totalUpstreamErrors   uint64
+	totalHeadersStripped  uint64
+	totalHeadersInjected  uint64
+	totalInjectedFailures uint64
+)
+
+// upstream is one member of the proxy's upstream pool.
+type upstream struct {
+	url          string
+	active       int64
+	requests     uint64
+	errors       uint64
+	latencyNanos uint64
+
+	// previous interval values, only touched by the stats goroutine
+	prevRequests, prevErrors, prevLatencyNanos uint64
+}
+
+// proxy forwards requests to a pool of upstreams picked by lbAlgo,
+// optionally removing and adding request headers on the way.
+type proxy struct {
+	upstreams    []*upstream
+	lbAlgo       string
+	rr           uint64
+	timeout      time.Duration
+	client       *fasthttp.Client
+	stripHeaders []string
+	addHeaders   [][2]string
+
+	// failureRate of requests get failureCode without contacting an upstream
+	failureRate float64
+	failureCode int
+}
+
+func newProxy(pool []string, lbAlgo string, timeout time.Duration, strip, add string) (*proxy, error) {
+	switch lbAlgo {
+	case "round-robin", "random", "least-connections":
+	default:
+		return nil, fmt.Errorf("unknown lb algorithm %q (want round-robin, random or least-connections)", lbAlgo)
+	}
+	p := &proxy{
+		lbAlgo:  lbAlgo,
+		timeout: timeout,
+		client: &fasthttp.Client{
+			NoDefaultUserAgentHeader:      true,
+			DisableHeaderNamesNormalizing: true,
+		},
+	}
+	for _, u := range pool {
+		if u = strings.TrimSpace(u); u == "" {
+			continue
+		}
+		if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+			return nil, fmt.Errorf("upstream %q must start with http:// or https://", u)
+		}
+		p.upstreams = append(p.upstreams, &upstream{url: strings.TrimSuffix(u, "/")})
+	}
+	if len(p.upstreams) == 0 {
+		return nil, fmt.Errorf("no upstreams configured")
+	}
+	for _, name := range strings.Split(strip, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			p.stripHeaders = append(p.stripHeaders, name)
+		}
+	}
+	for _, kv := range strings.Split(add, ",") {
+		if strings.TrimSpace(kv) == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(kv, ":")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("invalid header %q: want key:value", kv)
+		}
+		p.addHeaders = append(p.addHeaders, [2]string{strings.TrimSpace(k), strings.TrimSpace(v)})
+	}
+	return p, nil
+}
+
+// pick selects the upstream for the next request.
+func (p *proxy) pick() *upstream {
+	switch p.lbAlgo {
+	case "random":
+		return p.upstreams[rand.IntN(len(p.upstreams))]
+	case "least-connections":
+		best := p.upstreams[0]
+		for _, u := range p.upstreams[1:] {
+			if atomic.LoadInt64(&u.active) < atomic.LoadInt64(&best.active) {
+				best = u
+			}
+		}
+		return best
+	default:
+		n := atomic.AddUint64(&p.rr, 1)
+		return p.upstreams[(n-1)%uint64(len(p.upstreams))]
+	}
+}
+
+func (p *proxy) handle(ctx *fasthttp.RequestCtx) {
+	if p.failureRate > 0 && rand.Float64() < p.failureRate {
+		atomic.AddUint64(&totalInjectedFailures, 1)
+		ctx.SetStatusCode(p.failureCode)
+		ctx.SetContentType("text/plain; charset=utf-8")
+		ctx.SetBodyString("injected upstream failure")
+		return
+	}
+
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+
+	u := p.pick()
+	ctx.Request.CopyTo(req)
+	req.SetRequestURI(u.url + string(ctx.RequestURI()))
+	req.Header.SetHostBytes(req.URI().Host())
+
+	for _, name := range p.stripHeaders {
+		if n := delHeader(&req.Header, name); n > 0 {
+			atomic.AddUint64(&totalHeadersStripped, uint64(n))
+		}
+	}
+	for _, kv := range p.addHeaders {
+		req.Header.Set(kv[0], kv[1])
+		atomic.AddUint64(&totalHeadersInjected, 1)
+	}
+
+	atomic.AddUint64(&totalUpstreamRequests, 1)
+	atomic.AddUint64(&u.requests, 1)
+	atomic.AddInt64(&u.active, 1)
+	start := time.Now()
+	err := p.client.DoTimeout(req, resp, p.timeout)
+	atomic.AddUint64(&u.latencyNanos, uint64(time.Since(start)))
+	atomic.AddInt64(&u.active, -1)
+	if err != nil {
+		atomic.AddUint64(&totalUpstreamErrors, 1)
+		atomic.AddUint64(&u.errors, 1)
+		ctx.SetStatusCode(fasthttp.StatusBadGateway)
+		ctx.SetContentType("text/plain; charset=utf-8")
+		ctx.SetBodyString("upstream error")
+		return
+	}
+	copyUpstreamResponse(&ctx.Response, resp)
+}
+
+// copyUpstreamResponse copies the status, headers and body of src into dst.
+// Unlike Response.CopyTo it doesn't reset dst, so headers the server set
+// before the mode handler ran (protocol override, Connection: close, stats
+// and request ID headers) survive. Framing and connection headers are left
+// to the server, which writes its own.
+func copyUpstreamResponse(dst, src *fasthttp.Response) {
+	dst.SetStatusCode(src.StatusCode())
+	for k, v := range src.Header.All() {
+		switch {
+		case strings.EqualFold(string(k), fasthttp.HeaderConnection),
+			strings.EqualFold(string(k), fasthttp.HeaderContentLength),
+			strings.EqualFold(string(k), fasthttp.HeaderTransferEncoding),
+			strings.EqualFold(string(k), "Keep-Alive"):
+			continue
+		}
+		dst.Header.AddBytesKV(k, v)
+	}
+	dst.SetBody(src.Body())
+}
+
+type upstreamInterval struct {
+	url        string
+	requests   uint64
+	errors     uint64
+	avgLatency time.Duration
+	active     int64
+}
+
+// intervalStats returns per-upstream stats since the previous call. It must
+// only be called from the stats goroutine.
+func (p *proxy) intervalStats() []upstreamInterval {
+	items := make([]upstreamInterval, 0, len(p.upstreams))
+	for _, u := range p.upstreams {
+		req := atomic.LoadUint64(&u.requests)
+		errs := atomic.LoadUint64(&u.errors)
+		lat := atomic.LoadUint64(&u.latencyNanos)
+		it := upstreamInterval{
+			url:      u.url,
+			requests: req - u.prevRequests,
+			errors:   errs - u.prevErrors,
+			active:   atomic.LoadInt64(&u.active),
+		}
+		if it.requests > 0 {
+			it.avgLatency = time.Duration((lat - u.prevLatencyNanos) / it.requests)
+		}
+		u.prevRequests, u.prevErrors, u.prevLatencyNanos = req, errs, lat
+		items = append(items, it)
+	}
+	return items
+}
@@ -0,0 +1,14 @@
+package main
+
+import "github.com/valyala/fasthttp"
+
+// maxQueryParamKeys caps the number of distinct query param keys tracked.
+const maxQueryParamKeys = 200
+
+var queryParamCounts = newKeyedCounters(maxQueryParamKeys)
+
+func countQueryParams(args *fasthttp.Args) {
+	for k := range args.All() {
+		queryParamCounts.inc(k)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// statusBody is the fixed response -mode random-status-and-body sends for a
+// status code.
+type statusBody struct {
+	contentType string
+	body        []byte
+}
+
+// statusBodies lists the status codes -mode random-status-and-body can
+// return.
+var statusBodies = map[int]statusBody{
+	fasthttp.StatusOK:                  {"text/plain; charset=utf-8", []byte("OK")},
+	fasthttp.StatusCreated:             {"text/plain; charset=utf-8", []byte("Created")},
+	fasthttp.StatusBadRequest:          {"application/json", []byte(`{"error":"bad_request"}`)},
+	fasthttp.StatusNotFound:            {"text/plain; charset=utf-8", []byte("Not Found")},
+	fasthttp.StatusInternalServerError: {"text/plain; charset=utf-8", []byte("Internal Server Error")},
+}
+
+// randomStatusCounts counts responses per status code.
+var randomStatusCounts = newKeyedCounters(len(statusBodies))
+
+type statusWeight struct {
+	code int
+	// cumulative is the sum of this and all earlier weights
+	cumulative int
+}
+
+// parseResponseWeights parses a comma separated list of code:weight pairs,
+// e.g. "200:90,500:10". Codes must be in statusBodies.
+func parseResponseWeights(spec string) ([]statusWeight, error) {
+	var weights []statusWeight
+	total := 0
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		c, w, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid entry %q, want code:weight", entry)
+		}
+		code, err := strconv.Atoi(c)
+		if _, known := statusBodies[code]; err != nil || !known {
+			return nil, fmt.Errorf("unsupported status code %q (want one of %s)", c, supportedStatusCodes())
+		}
+		weight, err := strconv.Atoi(w)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid weight %q", w)
+		}
+		total += weight
+		weights = append(weights, statusWeight{code: code, cumulative: total})
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("weights must add up to more than 0")
+	}
+	return weights, nil
+}
+
+func supportedStatusCodes() string {
+	codes := make([]string, 0, len(statusBodies))
+	for code := range statusBodies {
+		codes = append(codes, strconv.Itoa(code))
+	}
+	sort.Strings(codes)
+	return strings.Join(codes, ", ")
+}
+
+// newRandomStatusHandler answers each request with a status code picked by
+// weight and that code's body and content type.
+func newRandomStatusHandler(weights []statusWeight) fasthttp.RequestHandler {
+	total := weights[len(weights)-1].cumulative
+	return func(ctx *fasthttp.RequestCtx) {
+		n := rand.IntN(total)
+		i := sort.Search(len(weights), func(i int) bool { return weights[i].cumulative > n })
+		code := weights[i].code
+		sb := statusBodies[code]
+		ctx.SetStatusCode(code)
+		ctx.SetContentType(sb.contentType)
+		ctx.SetBody(sb.body)
+		randomStatusCounts.inc(strconv.AppendInt(nil, int64(code), 10))
+	}
+}
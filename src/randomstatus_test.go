@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestParseResponseWeights(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    []statusWeight
+		wantErr bool
+	}{
+		{"200:90,500:10", []statusWeight{{200, 90}, {500, 100}}, false},
+		{" 200:1 , ,404:0,400:3", []statusWeight{{200, 1}, {404, 1}, {400, 4}}, false},
+		{"201:5", []statusWeight{{201, 5}}, false},
+		{"", nil, true},
+		{"200:0,500:0", nil, true},
+		{"200", nil, true},
+		{"299:10", nil, true},
+		{"abc:10", nil, true},
+		{"200:-1", nil, true},
+		{"200:x", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseResponseWeights(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseResponseWeights(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("parseResponseWeights(%q) = %v, want %v", tt.spec, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("parseResponseWeights(%q) = %v, want %v", tt.spec, got, tt.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	totalRateLimited   uint64
+	totalBurstConsumed uint64
+)
+
+// tokenBucket is one IP's state in a tokenBucketLimiter.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	// drained is set when the bucket runs dry and cleared once it has
+	// refilled completely, so each used-up burst is counted once
+	drained bool
+}
+
+// tokenBucketLimiter allows each IP bursts of up to burst requests,
+// refilled at rate per second.
+type tokenBucketLimiter struct {
+	rate    float64
+	burst   float64
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func newTokenBucketLimiter(rate float64, burst int) *tokenBucketLimiter {
+	return &tokenBucketLimiter{rate: rate, burst: float64(burst), buckets: make(map[string]*tokenBucket)}
+}
+
+// refill must be called with l.mu held.
+func (l *tokenBucketLimiter) refill(b *tokenBucket, now time.Time) {
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens == l.burst {
+		b.drained = false
+	}
+}
+
+// allow takes a token from ip's bucket. If there is none, it reports false
+// and how long until the next one is available.
+func (l *tokenBucketLimiter) allow(ip string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[ip]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[ip] = b
+	}
+	l.refill(b, now)
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	if b.tokens < 1 && !b.drained {
+		b.drained = true
+		atomic.AddUint64(&totalBurstConsumed, 1)
+	}
+	return true, 0
+}
+
+// utilization returns the average fraction of the burst in use across IPs
+// whose bucket isn't full, and how many such IPs there are. Full buckets
+// are dropped, since they're no different from a new one.
+func (l *tokenBucketLimiter) utilization(now time.Time) (float64, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var sum float64
+	for ip, b := range l.buckets {
+		l.refill(b, now)
+		if b.tokens == l.burst {
+			delete(l.buckets, ip)
+			continue
+		}
+		sum += 1 - b.tokens/l.burst
+	}
+	if len(l.buckets) == 0 {
+		return 0, 0
+	}
+	return sum / float64(len(l.buckets)), len(l.buckets)
+}
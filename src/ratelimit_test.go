@@ -0,0 +1,69 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketLimiter(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := newTokenBucketLimiter(2, 3)
+	burstsBefore := atomic.LoadUint64(&totalBurstConsumed)
+
+	steps := []struct {
+		ip       string
+		at       time.Duration
+		want     bool
+		wantWait time.Duration
+	}{
+		// a new IP gets the whole burst
+		{"a", 0, true, 0},
+		{"a", 0, true, 0},
+		{"a", 0, true, 0},
+		{"a", 0, false, 500 * time.Millisecond},
+		// other IPs have their own bucket
+		{"b", 0, true, 0},
+		// half a token doesn't allow a request
+		{"a", 250 * time.Millisecond, false, 250 * time.Millisecond},
+		{"a", 500 * time.Millisecond, true, 0},
+		{"a", 500 * time.Millisecond, false, 500 * time.Millisecond},
+		// refilling caps at the burst
+		{"a", 10 * time.Second, true, 0},
+		{"a", 10 * time.Second, true, 0},
+		{"a", 10 * time.Second, true, 0},
+		{"a", 10 * time.Second, false, 500 * time.Millisecond},
+	}
+	for i, s := range steps {
+		ok, wait := l.allow(s.ip, start.Add(s.at))
+		if ok != s.want || wait != s.wantWait {
+			t.Errorf("step %d: allow(%q, +%s) = %v, %s, want %v, %s", i, s.ip, s.at, ok, wait, s.want, s.wantWait)
+		}
+	}
+	// "a" used up its burst twice, with a full refill in between
+	if got := atomic.LoadUint64(&totalBurstConsumed) - burstsBefore; got != 2 {
+		t.Errorf("bursts consumed = %d, want 2", got)
+	}
+}
+
+func TestTokenBucketLimiterUtilization(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := newTokenBucketLimiter(1, 4)
+	l.allow("a", now)
+	l.allow("a", now)
+	l.allow("b", now)
+	l.allow("b", now)
+	l.allow("b", now)
+	l.allow("b", now)
+
+	if u, n := l.utilization(now); n != 2 || u != 0.75 {
+		t.Errorf("utilization = %v over %d IPs, want 0.75 over 2", u, n)
+	}
+	// "a" is full again after two seconds and dropped
+	if u, n := l.utilization(now.Add(2 * time.Second)); n != 1 || u != 0.5 {
+		t.Errorf("utilization = %v over %d IPs, want 0.5 over 1", u, n)
+	}
+	if _, n := l.utilization(now.Add(time.Minute)); n != 0 {
+		t.Errorf("utilization over %d IPs after refill, want 0", n)
+	}
+}
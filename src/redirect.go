@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"strconv"
+
+	"github.com/valyala/fasthttp"
+)
+
+// maxRedirectLoopIPs caps the number of client IPs tracked in -mode
+// redirect-loop.
+const maxRedirectLoopIPs = 1000
+
+// redirectLoopCounts counts followed loop redirects per client IP.
+var redirectLoopCounts = newKeyedCounters(maxRedirectLoopIPs)
+
+var loopPrefix = []byte("/loop/")
+
+// newRedirectLoopHandler answers every request with a 302 to the next hop of
+// /loop/0 .. /loop/depth-1, the last of which points back to /loop/0.
+// Requests outside /loop/ enter the loop at /loop/1, so the first hop is
+// numbered 1.
+func newRedirectLoopHandler(depth int) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		next := 1 % depth
+		if path := ctx.Path(); bytes.HasPrefix(path, loopPrefix) {
+			if n, err := strconv.Atoi(string(path[len(loopPrefix):])); err == nil && n >= 0 {
+				next = (n + 1) % depth
+			}
+			redirectLoopCounts.inc([]byte(ctx.RemoteIP().String()))
+		}
+		var buf [32]byte
+		ctx.Response.Header.SetBytesV("Location", strconv.AppendInt(append(buf[:0], loopPrefix...), int64(next), 10))
+		ctx.SetStatusCode(fasthttp.StatusFound)
+	}
+}
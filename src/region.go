@@ -0,0 +1,58 @@
+package main
+
+import "sort"
+
+// maxRegions caps the number of distinct -region-header values tracked.
+const maxRegions = 50
+
+// Per-region totals, each capped at maxRegions. Regions are reported from
+// regionRequests' keys.
+var (
+	regionRequests = newKeyedCounters(maxRegions)
+	regionBytes    = newKeyedCounters(maxRegions)
+	regionErrors   = newKeyedCounters(maxRegions)
+)
+
+// countRegion adds one request of size bytes to region's stats; isError
+// marks responses with a 4xx or 5xx status.
+func countRegion(region []byte, size uint64, isError bool) {
+	regionRequests.inc(region)
+	regionBytes.add(region, size)
+	if isError {
+		regionErrors.inc(region)
+	}
+}
+
+type regionInterval struct {
+	region                string
+	requests, bytes, errs uint64
+}
+
+// regionIntervals returns the top n regions by requests since prev, which is
+// updated to the current totals.
+func regionIntervals(prev map[string]regionInterval, n int) []regionInterval {
+	var items []regionInterval
+	for _, kc := range regionRequests.top(maxRegions) {
+		curr := regionInterval{
+			region:   kc.key,
+			requests: kc.count,
+			bytes:    regionBytes.get(kc.key),
+			errs:     regionErrors.get(kc.key),
+		}
+		p := prev[kc.key]
+		if curr.requests > p.requests {
+			items = append(items, regionInterval{
+				region:   kc.key,
+				requests: curr.requests - p.requests,
+				bytes:    curr.bytes - p.bytes,
+				errs:     curr.errs - p.errs,
+			})
+		}
+		prev[kc.key] = curr
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].requests > items[j].requests })
+	if len(items) > n {
+		items = items[:n]
+	}
+	return items
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	totalRequestIdsPropagated uint64
+	totalRequestIdsGenerated  uint64
+	requestIDSeq              uint64
+)
+
+// inboundRequestIDHeaders are the request headers -request-id-propagate
+// copies to the response, in order of preference.
+var inboundRequestIDHeaders = []string{"X-Request-Id", "X-Correlation-Id"}
+
+// requestIDPrefix makes generated IDs unique across server restarts.
+var requestIDPrefix = func() string {
+	var b [4]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}()
+
+// setRequestID sets the response request ID header. With propagate, an
+// inbound ID is copied verbatim (under header, or under its own name when
+// header is empty). Otherwise, and when no inbound ID exists, a new ID is
+// generated if header is set.
+func setRequestID(ctx *fasthttp.RequestCtx, header string, propagate bool) {
+	if propagate {
+		for _, name := range inboundRequestIDHeaders {
+			if v := peekHeader(&ctx.Request.Header, name); len(v) > 0 {
+				if header != "" {
+					name = header
+				}
+				ctx.Response.Header.SetBytesV(name, v)
+				atomic.AddUint64(&totalRequestIdsPropagated, 1)
+				return
+			}
+		}
+	}
+	if header == "" {
+		return
+	}
+
+	var buf [32]byte
+	b := append(buf[:0], requestIDPrefix...)
+	b = append(b, '-')
+	b = strconv.AppendUint(b, atomic.AddUint64(&requestIDSeq, 1), 16)
+	ctx.Response.Header.SetBytesV(header, b)
+	atomic.AddUint64(&totalRequestIdsGenerated, 1)
+}
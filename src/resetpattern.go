@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net"
+	"regexp"
+	"sync/atomic"
+
+	"github.com/valyala/fasthttp"
+)
+
+var totalPatternResets uint64
+
+// newResetOnPatternHandler RSTs the connection instead of responding when
+// the request body matches re, like a DPI middlebox would; other requests go
+// to next.
+func newResetOnPatternHandler(re *regexp.Regexp, next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		if !re.Match(ctx.Request.Body()) {
+			next(ctx)
+			return
+		}
+		// the hijack handler only sees fasthttp's wrapper around the
+		// connection, so look up the TCP connection now
+		cc := countingConnOf(ctx.Conn())
+		ctx.HijackSetNoResponse(true)
+		// returning from the hijack handler closes the connection
+		ctx.Hijack(func(net.Conn) {
+			if cc != nil && cc.resetOnClose() == nil {
+				atomic.AddUint64(&totalPatternResets, 1)
+			}
+		})
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"math/rand/v2"
+	"strconv"
+
+	"github.com/valyala/fasthttp"
+)
+
+// setRetryAfter adds a Retry-After of base plus up to jitter seconds to 429
+// and 503 responses that don't carry one yet.
+func setRetryAfter(ctx *fasthttp.RequestCtx, base, jitter int) {
+	switch ctx.Response.StatusCode() {
+	case fasthttp.StatusTooManyRequests, fasthttp.StatusServiceUnavailable:
+	default:
+		return
+	}
+	if len(ctx.Response.Header.Peek("Retry-After")) > 0 {
+		return
+	}
+	ctx.Response.Header.Set("Retry-After", strconv.Itoa(base+rand.IntN(jitter+1)))
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	queuedRequests     int64
+	totalWokenRequests uint64
+)
+
+// newSleepUntilHandler holds every request until wake and then answers it
+// with next, so that all requests queued up to then get their responses at
+// the same moment. Requests arriving after wake are answered right away.
+func newSleepUntilHandler(wake time.Time, next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	woken := make(chan struct{})
+	time.AfterFunc(time.Until(wake), func() {
+		log.Printf("[WAKE] releasing %d queued requests", atomic.LoadInt64(&queuedRequests))
+		close(woken)
+	})
+	return func(ctx *fasthttp.RequestCtx) {
+		select {
+		case <-woken:
+		default:
+			atomic.AddInt64(&queuedRequests, 1)
+			<-woken
+			atomic.AddInt64(&queuedRequests, -1)
+			atomic.AddUint64(&totalWokenRequests, 1)
+		}
+		next(ctx)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"sync/atomic"
+
+	"github.com/valyala/fasthttp"
+)
+
+var totalSmugglingAttempts uint64
+
+// smugglingReasons checks the raw request headers for the framing conflicts
+// of RFC 7230 section 3.3.3 that reach the handler. fasthttp already rejects
+// duplicate Content-Length headers and unknown transfer codings itself.
+func smugglingReasons(h *fasthttp.RequestHeader) []string {
+	var contentLengths, transferEncodings int
+	for _, line := range bytes.Split(h.RawHeaders(), []byte("\r\n")) {
+		k, _, ok := bytes.Cut(line, []byte(":"))
+		if !ok {
+			continue
+		}
+		switch k := bytes.TrimSpace(k); {
+		case bytes.EqualFold(k, []byte("Content-Length")):
+			contentLengths++
+		case bytes.EqualFold(k, []byte("Transfer-Encoding")):
+			transferEncodings++
+		}
+	}
+
+	var reasons []string
+	if contentLengths > 0 && transferEncodings > 0 {
+		reasons = append(reasons, "both Content-Length and Transfer-Encoding")
+	}
+	if transferEncodings > 1 {
+		reasons = append(reasons, "multiple Transfer-Encoding headers")
+	}
+	if transferEncodings > 0 && !h.IsHTTP11() {
+		reasons = append(reasons, "Transfer-Encoding in an HTTP/1.0 request")
+	}
+	return reasons
+}
+
+// newSmugglingDetectHandler logs requests with ambiguous framing, headers
+// and all, and then serves them with next like any other request.
+func newSmugglingDetectHandler(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		h := &ctx.Request.Header
+		if reasons := smugglingReasons(h); len(reasons) > 0 {
+			atomic.AddUint64(&totalSmugglingAttempts, 1)
+			log.Printf("warning: possible request smuggling from %s (%s):\n%s %s %s\r\n%s",
+				ctx.RemoteIP(), strings.Join(reasons, ", "),
+				h.Method(), h.RequestURI(), h.Protocol(), h.RawHeaders())
+		}
+		next(ctx)
+	}
+}
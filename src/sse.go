@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// sseEventsPerStream is how many events each -mode sse-close stream gets
+// before the server closes it.
+const sseEventsPerStream = 3
+
+var (
+	totalSSEStreams    uint64
+	totalSSEReconnects uint64
+)
+
+// newSSECloseHandler sends a few server-sent events and then closes the
+// connection without a retry: field, leaving the reconnect delay to the
+// client's default. Reconnects, recognized by Last-Event-ID, are held for
+// delay before their stream starts and pick up the event ids where the
+// previous stream left off.
+func newSSECloseHandler(delay time.Duration) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		atomic.AddUint64(&totalSSEStreams, 1)
+		next := uint64(1)
+		if last := peekHeader(&ctx.Request.Header, "Last-Event-ID"); len(last) > 0 {
+			atomic.AddUint64(&totalSSEReconnects, 1)
+			if id, err := strconv.ParseUint(string(last), 10, 64); err == nil {
+				next = id + 1
+			}
+			time.Sleep(delay)
+		}
+
+		ctx.SetStatusCode(fasthttp.StatusOK)
+		ctx.SetContentType("text/event-stream")
+		ctx.Response.Header.Set("Cache-Control", "no-cache")
+		ctx.SetConnectionClose()
+		ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+			for i := uint64(0); i < sseEventsPerStream; i++ {
+				fmt.Fprintf(w, "id: %d\ndata: event %d\n\n", next+i, next+i)
+				if w.Flush() != nil {
+					return
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// statsSnapshot is the machine readable form of one stats interval, as
+// published by the stats exporters.
+type statsSnapshot struct {
+	Time          int64   `json:"time"`
+	Instance      string  `json:"instance"`
+	IntervalSecs  float64 `json:"interval_seconds"`
+	RPS           uint64  `json:"rps"`
+	BPS           uint64  `json:"bps"`
+	AvgReqBytes   float64 `json:"avg_req_bytes"`
+	AcceptsPerSec uint64  `json:"accepts_per_second"`
+	ClosesPerSec  uint64  `json:"closes_per_second"`
+	AcceptErrors  uint64  `json:"accept_errors"`
+	Concurrent    int64   `json:"concurrent_requests"`
+	TotalRequests uint64  `json:"total_requests"`
+	TotalBytes    uint64  `json:"total_bytes"`
+	MethodGet     uint64  `json:"method_get"`
+	MethodPost    uint64  `json:"method_post"`
+	MethodOther   uint64  `json:"method_other"`
+	UptimeSeconds int64   `json:"uptime_seconds"`
+}
+
+// writeFinalStats writes a snapshot covering the whole run to path as JSON.
+// Rates are averaged over the uptime.
+func writeFinalStats(path, instance string) error {
+	uptime := time.Since(startTime)
+	perSec := func(n uint64) uint64 {
+		if uptime < time.Second {
+			return n
+		}
+		return uint64(float64(n) / uptime.Seconds())
+	}
+	req := atomic.LoadUint64(&totalRequests)
+	b := atomic.LoadUint64(&totalBytes)
+	avg := 0.0
+	if req > 0 {
+		avg = float64(b) / float64(req)
+	}
+	data, err := json.MarshalIndent(&statsSnapshot{
+		Time:          time.Now().UnixMilli(),
+		Instance:      instance,
+		IntervalSecs:  uptime.Seconds(),
+		RPS:           perSec(req),
+		BPS:           perSec(b),
+		AvgReqBytes:   avg,
+		AcceptsPerSec: perSec(atomic.LoadUint64(&totalAccepted)),
+		ClosesPerSec:  perSec(atomic.LoadUint64(&totalClosed)),
+		AcceptErrors:  atomic.LoadUint64(&totalAcceptErrors),
+		Concurrent:    atomic.LoadInt64(&concurrentRequests),
+		TotalRequests: req,
+		TotalBytes:    b,
+		MethodGet:     atomic.LoadUint64(&methods.get),
+		MethodPost:    atomic.LoadUint64(&methods.post),
+		MethodOther:   atomic.LoadUint64(&methods.other),
+		UptimeSeconds: int64(uptime.Seconds()),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
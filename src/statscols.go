@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// statsColumns lists the columns -stats-cols can pick for the total stats
+// line, in the order of the default line.
+var statsColumns = []string{"rps", "bps", "conns", "avg", "totals", "methods", "uptime", "concurrent", "p50", "p90", "p99", "trace"}
+
+// defaultStatsCols are the columns of the total stats line without
+// -stats-cols.
+var defaultStatsCols = []string{"rps", "bps", "conns", "avg", "totals", "methods", "uptime", "trace"}
+
+// parseStatsCols splits a comma separated -stats-cols list into known
+// columns, in the given order, and unknown names.
+func parseStatsCols(spec string) (cols, unknown []string) {
+	for _, c := range strings.Split(spec, ",") {
+		c = strings.ToLower(strings.TrimSpace(c))
+		if c == "" {
+			continue
+		}
+		known := false
+		for _, sc := range statsColumns {
+			if c == sc {
+				known = true
+				break
+			}
+		}
+		if known {
+			cols = append(cols, c)
+		} else {
+			unknown = append(unknown, c)
+		}
+	}
+	return cols, unknown
+}
+
+// statsLine holds one interval's values for the total stats line.
+type statsLine struct {
+	rps, bps              uint64
+	acceptRate, closeRate uint64
+	acceptErrors          uint64
+	avg                   float64
+	totalReq, totalBytes  uint64
+	get, post, other      uint64
+	uptime                time.Duration
+	concurrent            int64
+	latency               []uint64 // interval histogram
+	traceID               string   // empty without -request-trace-header
+}
+
+// format renders the given columns the way the default line shows them.
+func (s *statsLine) format(cols []string) string {
+	parts := make([]string, 0, len(cols))
+	for _, c := range cols {
+		switch c {
+		case "rps":
+			parts = append(parts, fmt.Sprintf("req/s ~ %d", s.rps))
+		case "bps":
+			parts = append(parts, fmt.Sprintf("bytes/s ~ %d", s.bps))
+		case "conns":
+			parts = append(parts, fmt.Sprintf("conns: accept/s ~ %d close/s ~ %d accept-errors=%d", s.acceptRate, s.closeRate, s.acceptErrors))
+		case "avg":
+			parts = append(parts, fmt.Sprintf("avg req %.1f B", s.avg))
+		case "totals":
+			parts = append(parts, fmt.Sprintf("totals: %d req, %d B", s.totalReq, s.totalBytes))
+		case "methods":
+			parts = append(parts, fmt.Sprintf("methods: GET=%d POST=%d OTHER=%d", s.get, s.post, s.other))
+		case "uptime":
+			parts = append(parts, "uptime="+s.uptime.String())
+		case "concurrent":
+			parts = append(parts, fmt.Sprintf("concurrent=%d", s.concurrent))
+		case "p50":
+			parts = append(parts, "p50="+roundLatency(histQuantile(s.latency, 0.50)).String())
+		case "p90":
+			parts = append(parts, "p90="+roundLatency(histQuantile(s.latency, 0.90)).String())
+		case "p99":
+			parts = append(parts, "p99="+roundLatency(histQuantile(s.latency, 0.99)).String())
+		case "trace":
+			if s.traceID != "" {
+				parts = append(parts, "last_trace_id="+s.traceID)
+			}
+		}
+	}
+	return strings.Join(parts, " | ")
+}
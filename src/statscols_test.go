@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseStatsCols(t *testing.T) {
+	tests := []struct {
+		spec        string
+		wantCols    []string
+		wantUnknown []string
+	}{
+		{"", nil, nil},
+		{"rps", []string{"rps"}, nil},
+		{" P99 , rps,,uptime ", []string{"p99", "rps", "uptime"}, nil},
+		{"rps,nope,bps,p42", []string{"rps", "bps"}, []string{"nope", "p42"}},
+	}
+	for _, tt := range tests {
+		cols, unknown := parseStatsCols(tt.spec)
+		if strings.Join(cols, ",") != strings.Join(tt.wantCols, ",") || strings.Join(unknown, ",") != strings.Join(tt.wantUnknown, ",") {
+			t.Errorf("parseStatsCols(%q) = %v, %v, want %v, %v", tt.spec, cols, unknown, tt.wantCols, tt.wantUnknown)
+		}
+	}
+}
+
+func TestStatsLineFormat(t *testing.T) {
+	line := statsLine{
+		rps: 10, bps: 2000, acceptRate: 1, closeRate: 2, acceptErrors: 3,
+		avg: 200, totalReq: 100, totalBytes: 20000, get: 90, post: 9, other: 1,
+		uptime: 5 * time.Second, concurrent: 4,
+	}
+	tests := []struct {
+		cols    []string
+		traceID string
+		want    string
+	}{
+		{defaultStatsCols, "", "req/s ~ 10 | bytes/s ~ 2000 | conns: accept/s ~ 1 close/s ~ 2 accept-errors=3 | avg req 200.0 B | totals: 100 req, 20000 B | methods: GET=90 POST=9 OTHER=1 | uptime=5s"},
+		{defaultStatsCols, "-", "req/s ~ 10 | bytes/s ~ 2000 | conns: accept/s ~ 1 close/s ~ 2 accept-errors=3 | avg req 200.0 B | totals: 100 req, 20000 B | methods: GET=90 POST=9 OTHER=1 | uptime=5s | last_trace_id=-"},
+		{[]string{"concurrent", "rps"}, "", "concurrent=4 | req/s ~ 10"},
+		{[]string{"trace"}, "abc", "last_trace_id=abc"},
+	}
+	for _, tt := range tests {
+		line.traceID = tt.traceID
+		if got := line.format(tt.cols); got != tt.want {
+			t.Errorf("format(%v) = %q, want %q", tt.cols, got, tt.want)
+		}
+	}
+}
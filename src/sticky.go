@@ -0,0 +1,16 @@
+package main
+
+import "hash/fnv"
+
+var (
+	totalStickyRequests    uint64
+	totalMisroutedRequests uint64
+)
+
+// stickyInstance returns the instance a session should be routed to: the
+// FNV-1a hash of its ID modulo the number of instances.
+func stickyInstance(session []byte, instances int) int {
+	h := fnv.New32a()
+	h.Write(session)
+	return int(h.Sum32() % uint32(instances))
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"math/rand/v2"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	totalStorms      uint64
+	totalStormResets uint64
+)
+
+// resetStorm periodically opens a window during which a fraction of the
+// connections that send a request get RST instead of a response.
+type resetStorm struct {
+	fraction    float64
+	stormActive atomic.Bool
+	// epoch numbers the storms, so each connection is picked or spared
+	// only once per storm
+	epoch atomic.Uint64
+}
+
+// run starts a storm every interval and ends it after duration.
+func (s *resetStorm) run(interval, duration time.Duration) {
+	for range time.Tick(interval) {
+		s.epoch.Add(1)
+		s.stormActive.Store(true)
+		atomic.AddUint64(&totalStorms, 1)
+		time.Sleep(duration)
+		s.stormActive.Store(false)
+	}
+}
+
+// wrap serves requests with next, except for those arriving during a storm
+// on a connection picked for it, which is reset.
+func (s *resetStorm) wrap(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		cc := countingConnOf(ctx.Conn())
+		if !s.stormActive.Load() || cc == nil {
+			next(ctx)
+			return
+		}
+		epoch := s.epoch.Load()
+		if cc.stormEpoch == epoch || rand.Float64() >= s.fraction {
+			// spared for the rest of this storm
+			cc.stormEpoch = epoch
+			next(ctx)
+			return
+		}
+		ctx.HijackSetNoResponse(true)
+		// returning from the hijack handler closes the connection
+		ctx.Hijack(func(net.Conn) {
+			if cc.resetOnClose() == nil {
+				atomic.AddUint64(&totalStormResets, 1)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// timeWindow overrides the ok response between start and end, given as
+// minutes since local midnight. Windows with end <= start wrap around
+// midnight.
+type timeWindow struct {
+	spec       string
+	start, end int
+	code       int
+	body       []byte
+}
+
+// currentCode and currentBody hold the override of the active time window;
+// currentCode is 0 outside of all windows.
+var (
+	currentCode int64
+	currentBody atomic.Pointer[[]byte]
+)
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, want HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// parseTimeWindows parses comma-separated HH:MM-HH:MM:code:body entries.
+func parseTimeWindows(spec string) ([]timeWindow, error) {
+	var windows []timeWindow
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) < 12 || entry[11] != ':' {
+			return nil, fmt.Errorf("invalid entry %q, want HH:MM-HH:MM:code:body", entry)
+		}
+		from, to, ok := strings.Cut(entry[:11], "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid entry %q, want HH:MM-HH:MM:code:body", entry)
+		}
+		w := timeWindow{spec: entry[:11]}
+		var err error
+		if w.start, err = parseClock(from); err != nil {
+			return nil, err
+		}
+		if w.end, err = parseClock(to); err != nil {
+			return nil, err
+		}
+		code, body, _ := strings.Cut(entry[12:], ":")
+		if w.code, err = strconv.Atoi(code); err != nil || w.code < 100 || w.code > 999 {
+			return nil, fmt.Errorf("invalid status code %q", code)
+		}
+		w.body = []byte(body)
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+func (w *timeWindow) contains(minute int) bool {
+	if w.end <= w.start {
+		return minute >= w.start || minute < w.end
+	}
+	return minute >= w.start && minute < w.end
+}
+
+// updateTimeWindow publishes the first window containing t, if any.
+func updateTimeWindow(windows []timeWindow, t time.Time, active *int) {
+	minute := t.Hour()*60 + t.Minute()
+	idx := -1
+	for i := range windows {
+		if windows[i].contains(minute) {
+			idx = i
+			break
+		}
+	}
+	if idx == *active {
+		return
+	}
+	*active = idx
+	if idx < 0 {
+		atomic.StoreInt64(&currentCode, 0)
+		log.Printf("[TIME-SWITCH] no window active, serving default response")
+		return
+	}
+	w := &windows[idx]
+	currentBody.Store(&w.body)
+	atomic.StoreInt64(&currentCode, int64(w.code))
+	log.Printf("[TIME-SWITCH] window %s active, serving %d", w.spec, w.code)
+}
+
+// runTimeWindows applies windows now and then re-checks every minute.
+func runTimeWindows(windows []timeWindow) {
+	active := -1
+	updateTimeWindow(windows, time.Now(), &active)
+	for now := range time.Tick(time.Minute) {
+		updateTimeWindow(windows, now, &active)
+	}
+}
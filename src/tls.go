@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsVersionCounts counts negotiated TLS versions, once per connection.
+var tlsVersionCounts = newKeyedCounters(len(tlsVersions))
+
+func parseTLSVersion(s string) (uint16, error) {
+	v, ok := tlsVersions[s]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version (want 1.0, 1.1, 1.2 or 1.3)")
+	}
+	return v, nil
+}
+
+// parseCipherSuites maps a comma separated list of cipher suite names (as
+// reported by tls.CipherSuiteName) to their IDs.
+func parseCipherSuites(s string) ([]uint16, error) {
+	known := make(map[string]*tls.CipherSuite)
+	for _, cs := range tls.CipherSuites() {
+		known[cs.Name] = cs
+	}
+	for _, cs := range tls.InsecureCipherSuites() {
+		known[cs.Name] = cs
+	}
+
+	var ids []uint16
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		cs, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		ids = append(ids, cs.ID)
+	}
+	return ids, nil
+}
+
+// tls13Only reports whether every listed suite is a TLS 1.3 suite. IDs that
+// aren't known to crypto/tls count as not TLS 1.3-only.
+func tls13Only(ids []uint16) bool {
+	suites := append(tls.CipherSuites(), tls.InsecureCipherSuites()...)
+	for _, id := range ids {
+		only13 := false
+		for _, cs := range suites {
+			if cs.ID == id {
+				only13 = len(cs.SupportedVersions) == 1 && cs.SupportedVersions[0] == tls.VersionTLS13
+				break
+			}
+		}
+		if !only13 {
+			return false
+		}
+	}
+	return true
+}
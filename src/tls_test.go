@@ -0,0 +1,56 @@
+package main
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestTLS13Only(t *testing.T) {
+	tests := []struct {
+		name string
+		ids  []uint16
+		want bool
+	}{
+		{"empty", nil, true},
+		{"tls13", []uint16{tls.TLS_AES_128_GCM_SHA256, tls.TLS_CHACHA20_POLY1305_SHA256}, true},
+		{"tls12", []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}, false},
+		{"mixed", []uint16{tls.TLS_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}, false},
+		{"insecure rc4", []uint16{tls.TLS_RSA_WITH_RC4_128_SHA}, false},
+		{"insecure 3des", []uint16{tls.TLS_AES_128_GCM_SHA256, tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA}, false},
+		{"unknown", []uint16{0xfefe}, false},
+	}
+	for _, tt := range tests {
+		if got := tls13Only(tt.ids); got != tt.want {
+			t.Errorf("%s: tls13Only(%v) = %v, want %v", tt.name, tt.ids, got, tt.want)
+		}
+	}
+}
+
+func TestParseCipherSuites(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []uint16
+		wantErr bool
+	}{
+		{"TLS_AES_128_GCM_SHA256", []uint16{tls.TLS_AES_128_GCM_SHA256}, false},
+		{" TLS_AES_128_GCM_SHA256 , TLS_RSA_WITH_RC4_128_SHA,", []uint16{tls.TLS_AES_128_GCM_SHA256, tls.TLS_RSA_WITH_RC4_128_SHA}, false},
+		{"TLS_NOPE", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseCipherSuites(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseCipherSuites(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("parseCipherSuites(%q) = %v, want %v", tt.in, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("parseCipherSuites(%q) = %v, want %v", tt.in, got, tt.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bufio"
+	"hash/crc32"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	totalTrailerResponses  uint64
+	totalTrailerSendErrors uint64
+)
+
+// newChunkedTrailersHandler builds the response with respond and sends its
+// body chunked, followed by X-Checksum (the body's CRC32 in hex) and
+// X-Timestamp (RFC 3339 with nanoseconds) trailers announced up front in a
+// Trailer header.
+func newChunkedTrailersHandler(respond fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		respond(ctx)
+		body := append([]byte(nil), ctx.Response.Body()...)
+
+		h := &ctx.Response.Header
+		h.SetTrailer("X-Checksum, X-Timestamp")
+		// trailer values are held back by fasthttp until after the last chunk
+		h.Set("X-Checksum", strconv.FormatUint(uint64(crc32.ChecksumIEEE(body)), 16))
+		h.Set("X-Timestamp", time.Now().UTC().Format(time.RFC3339Nano))
+
+		ctx.Response.SetBodyStreamWriter(func(w *bufio.Writer) {
+			w.Write(body)
+			// fasthttp writes the trailers right after the stream ends, so a
+			// connection that fails here won't get them either
+			if err := w.Flush(); err != nil {
+				atomic.AddUint64(&totalTrailerSendErrors, 1)
+				return
+			}
+			atomic.AddUint64(&totalTrailerResponses, 1)
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net"
+	"sync/atomic"
+
+	"github.com/valyala/fasthttp"
+)
+
+var totalTruncatedResponses uint64
+
+// newCloseAfterHeadersHandler builds the response with respond but only
+// writes its status line and headers before closing the connection, so
+// clients see a response whose body never arrives.
+func newCloseAfterHeadersHandler(respond fasthttp.RequestHandler, serverName string) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		respond(ctx)
+		contentLength := -1
+		if !ctx.Response.IsBodyStream() {
+			contentLength = len(ctx.Response.Body())
+		}
+		hdr := serializeResponse(ctx, serverName, contentLength, nil)
+		writeRawResponse(ctx, hdr, func(net.Conn) {
+			atomic.AddUint64(&totalTruncatedResponses, 1)
+		})
+	}
+}
+
+var totalPartialResponses uint64
+
+// newPartialResponseHandler builds the response with respond but closes
+// the connection after the first n bytes of its body, so the client gets
+// less than the Content-Length it was promised. Bodies of n bytes or less
+// are padded with zeros to n+1 bytes first.
+func newPartialResponseHandler(respond fasthttp.RequestHandler, serverName string, n int) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		respond(ctx)
+		body := ctx.Response.Body()
+		if len(body) <= n {
+			body = append(append([]byte(nil), body...), make([]byte, n+1-len(body))...)
+		}
+		resp := serializeResponse(ctx, serverName, len(body), body[:n])
+		writeRawResponse(ctx, resp, func(net.Conn) {
+			atomic.AddUint64(&totalPartialResponses, 1)
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// tunnelDialTimeout bounds connecting to a CONNECT target.
+const tunnelDialTimeout = 5 * time.Second
+
+var (
+	totalTunnels          uint64
+	totalTunnelsRejected  uint64
+	totalTunnelDialErrors uint64
+	totalTunnelBytesUp    uint64 // client to target
+	totalTunnelBytesDown  uint64 // target to client
+	activeTunnels         int64
+)
+
+// tunnelAllowList restricts CONNECT targets to hosts matching one of its
+// globs or resolving to an address in one of its networks. An empty list
+// allows every target.
+type tunnelAllowList struct {
+	nets  []*net.IPNet
+	globs []string
+}
+
+// parseTunnelAllowList parses a comma separated list of CIDRs and hostname
+// globs such as "10.0.0.0/8,*.example.com".
+func parseTunnelAllowList(spec string) (*tunnelAllowList, error) {
+	al := &tunnelAllowList{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if strings.Contains(entry, "/") {
+			_, n, err := net.ParseCIDR(entry)
+			if err != nil {
+				return nil, err
+			}
+			al.nets = append(al.nets, n)
+			continue
+		}
+		if _, err := path.Match(entry, ""); err != nil {
+			return nil, fmt.Errorf("invalid glob %q: %v", entry, err)
+		}
+		al.globs = append(al.globs, strings.ToLower(entry))
+	}
+	return al, nil
+}
+
+func (al *tunnelAllowList) empty() bool {
+	return len(al.nets) == 0 && len(al.globs) == 0
+}
+
+// allowHost reports whether host is allowed by name alone.
+func (al *tunnelAllowList) allowHost(host string) bool {
+	host = strings.ToLower(host)
+	for _, g := range al.globs {
+		if ok, _ := path.Match(g, host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (al *tunnelAllowList) allowIP(ip net.IP) bool {
+	for _, n := range al.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// newTunnelHandler turns the server into an HTTP CONNECT proxy: CONNECT
+// requests to an allowed host:port get a tunnel to it, anything else goes
+// to next.
+func newTunnelHandler(allow *tunnelAllowList, next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		if !ctx.IsConnect() {
+			next(ctx)
+			return
+		}
+		target := string(ctx.RequestURI())
+		host, port, err := net.SplitHostPort(target)
+		if err != nil {
+			ctx.Error("invalid CONNECT target", fasthttp.StatusBadRequest)
+			return
+		}
+
+		// dial the address that was checked, so a name can't resolve to
+		// something else between the check and the dial
+		dialCtx, cancel := context.WithTimeout(context.Background(), tunnelDialTimeout)
+		defer cancel()
+		ips, err := net.DefaultResolver.LookupIP(dialCtx, "ip", host)
+		if err != nil || len(ips) == 0 {
+			atomic.AddUint64(&totalTunnelDialErrors, 1)
+			ctx.Error("cannot resolve CONNECT target", fasthttp.StatusBadGateway)
+			return
+		}
+		ip := ips[0]
+		if !allow.empty() && !allow.allowHost(host) && !allow.allowIP(ip) {
+			atomic.AddUint64(&totalTunnelsRejected, 1)
+			ctx.Error("CONNECT target not allowed", fasthttp.StatusForbidden)
+			return
+		}
+		var d net.Dialer
+		upstream, err := d.DialContext(dialCtx, "tcp", net.JoinHostPort(ip.String(), port))
+		if err != nil {
+			atomic.AddUint64(&totalTunnelDialErrors, 1)
+			ctx.Error("cannot connect to CONNECT target", fasthttp.StatusBadGateway)
+			return
+		}
+
+		atomic.AddUint64(&totalTunnels, 1)
+		// a 2xx response to CONNECT must not have Content-Length, which
+		// fasthttp would add, so it's written by hand
+		ctx.HijackSetNoResponse(true)
+		ctx.Hijack(func(c net.Conn) {
+			atomic.AddInt64(&activeTunnels, 1)
+			defer atomic.AddInt64(&activeTunnels, -1)
+			defer upstream.Close()
+			if _, err := io.WriteString(c, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
+				return
+			}
+			go func() {
+				// c also returns whatever the client sent right after
+				// the CONNECT request
+				io.Copy(countingWriter{upstream, &totalTunnelBytesUp}, c)
+				if tc, ok := upstream.(*net.TCPConn); ok {
+					tc.CloseWrite()
+				}
+			}()
+			io.Copy(countingWriter{c, &totalTunnelBytesDown}, upstream)
+			// returning closes the client connection, which ends the copy
+			// in the other direction
+		})
+	}
+}
+
+// countingWriter adds the number of bytes written to n.
+type countingWriter struct {
+	w io.Writer
+	n *uint64
+}
+
+func (cw countingWriter) Write(b []byte) (int, error) {
+	n, err := cw.w.Write(b)
+	atomic.AddUint64(cw.n, uint64(n))
+	return n, err
+}
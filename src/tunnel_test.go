@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseTunnelAllowList(t *testing.T) {
+	tests := []struct {
+		spec      string
+		wantNets  int
+		wantGlobs int
+		wantErr   bool
+	}{
+		{"", 0, 0, false},
+		{"10.0.0.0/8", 1, 0, false},
+		{" 10.0.0.0/8 , *.Example.com,, fd00::/8 ", 2, 1, false},
+		{"localhost,db-?.internal", 0, 2, false},
+		{"10.0.0.0/33", 0, 0, true},
+		{"not/a/cidr", 0, 0, true},
+		{"[abc", 0, 0, true},
+	}
+	for _, tt := range tests {
+		al, err := parseTunnelAllowList(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseTunnelAllowList(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if len(al.nets) != tt.wantNets || len(al.globs) != tt.wantGlobs {
+			t.Errorf("parseTunnelAllowList(%q) = %d nets, %d globs, want %d, %d", tt.spec, len(al.nets), len(al.globs), tt.wantNets, tt.wantGlobs)
+		}
+		if al.empty() != (tt.wantNets+tt.wantGlobs == 0) {
+			t.Errorf("parseTunnelAllowList(%q).empty() = %v", tt.spec, al.empty())
+		}
+	}
+}
+
+func TestTunnelAllowListMatch(t *testing.T) {
+	al, err := parseTunnelAllowList("10.0.0.0/8,fd00::/8,*.example.com,db-?.internal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := []struct {
+		host string
+		want bool
+	}{
+		{"api.example.com", true},
+		{"API.EXAMPLE.COM", true},
+		{"example.com", false},
+		{"a.b.example.com", true},
+		{"db-1.internal", true},
+		{"db-12.internal", false},
+		{"10.1.2.3", false},
+	}
+	for _, tt := range hosts {
+		if got := al.allowHost(tt.host); got != tt.want {
+			t.Errorf("allowHost(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+	ips := []struct {
+		ip   string
+		want bool
+	}{
+		{"10.1.2.3", true},
+		{"11.0.0.1", false},
+		{"fd00::1", true},
+		{"fe80::1", false},
+	}
+	for _, tt := range ips {
+		if got := al.allowIP(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("allowIP(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
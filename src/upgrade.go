@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"net"
+	"sync/atomic"
+
+	"github.com/valyala/fasthttp"
+)
+
+// maxUpgradeProtocols caps the number of distinct Upgrade values tracked.
+const maxUpgradeProtocols = 50
+
+var upgradeCounts = newKeyedCounters(maxUpgradeProtocols)
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// respondUpgrade answers protocol upgrade requests with 101 Switching
+// Protocols and closes the connection right after, without ever speaking
+// the new protocol. It reports false for requests without an Upgrade header.
+func respondUpgrade(ctx *fasthttp.RequestCtx) bool {
+	proto := bytes.ToLower(bytes.TrimSpace(peekHeader(&ctx.Request.Header, "Upgrade")))
+	if len(proto) == 0 {
+		return false
+	}
+	upgradeCounts.inc(proto)
+
+	switch string(proto) {
+	case "websocket":
+		key := peekHeader(&ctx.Request.Header, "Sec-WebSocket-Key")
+		if len(key) == 0 {
+			ctx.SetStatusCode(fasthttp.StatusBadRequest)
+			ctx.SetBodyString("missing Sec-WebSocket-Key")
+			return true
+		}
+		// key aliases fasthttp's header buffer, so hash it without appending
+		h := sha1.New()
+		h.Write(key)
+		h.Write([]byte(websocketGUID))
+		ctx.Response.Header.Set("Upgrade", "websocket")
+		ctx.Response.Header.Set("Sec-WebSocket-Accept", base64.StdEncoding.EncodeToString(h.Sum(nil)))
+	case "h2c":
+		ctx.Response.Header.Set("Upgrade", "h2c")
+	default:
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetBodyString("unsupported upgrade protocol")
+		return true
+	}
+
+	ctx.Response.Header.Set("Connection", "Upgrade")
+	ctx.SetStatusCode(fasthttp.StatusSwitchingProtocols)
+	// the hijack handler runs once the 101 has been written; returning from
+	// it closes the connection
+	ctx.Hijack(func(net.Conn) {})
+	return true
+}
+
+var totalUpgradeRequired uint64
+
+// respondUpgradeRequired answers with 426 Upgrade Required, asking the
+// client to switch to TLS 1.3 on the same connection.
+func respondUpgradeRequired(ctx *fasthttp.RequestCtx) {
+	atomic.AddUint64(&totalUpgradeRequired, 1)
+	ctx.SetStatusCode(fasthttp.StatusUpgradeRequired)
+	ctx.Response.Header.Set("Upgrade", "TLS/1.3, HTTP/1.1")
+	ctx.Response.Header.Set("Connection", "Upgrade")
+	ctx.SetContentType("text/plain; charset=utf-8")
+	ctx.SetBodyString("This resource requires a protocol upgrade. Retry the request with one of the protocols in the Upgrade header, e.g. by sending Upgrade: TLS/1.3 and Connection: Upgrade.\n")
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// serverVersion is the fast-ok-server release reported by -expose-server-info.
+const serverVersion = "1.0"
+
+// serverInfo returns a Server header value naming the server, Go and
+// fasthttp versions, e.g. "fast-ok-server/1.0 go/1.24.5 fasthttp/1.65.0".
+func serverInfo() string {
+	info := serverName + "/" + serverVersion + " go/" + strings.TrimPrefix(runtime.Version(), "go")
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range bi.Deps {
+			if dep.Path == "github.com/valyala/fasthttp" {
+				info += " fasthttp/" + strings.TrimPrefix(dep.Version, "v")
+				break
+			}
+		}
+	}
+	return info
+}
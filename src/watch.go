@@ -0,0 +1,48 @@
+package main
+
+import (
+	"log"
+	"os"
+	"syscall"
+	"time"
+)
+
+// watchExecutable polls the running binary's ModTime and replaces the
+// process with the new binary, using the same arguments, once it changed.
+// A change is only acted on after the ModTime stayed the same for one more
+// poll, so a binary that is still being written isn't executed.
+func watchExecutable(interval time.Duration) {
+	exe, err := os.Executable()
+	if err != nil {
+		log.Printf("[WATCH] disabled: %v", err)
+		return
+	}
+	fi, err := os.Stat(exe)
+	if err != nil {
+		log.Printf("[WATCH] disabled: %v", err)
+		return
+	}
+	lastMod := fi.ModTime()
+	var pending time.Time
+
+	for range time.Tick(interval) {
+		fi, err := os.Stat(exe)
+		if err != nil {
+			// the binary may be replaced by a rename right now
+			continue
+		}
+		mod := fi.ModTime()
+		switch {
+		case mod.Equal(lastMod):
+			pending = time.Time{}
+		case !mod.Equal(pending):
+			pending = mod
+		default:
+			log.Printf("[WATCH] reloading %s", exe)
+			if err := syscall.Exec(exe, os.Args, os.Environ()); err != nil {
+				log.Printf("[WATCH] exec error: %v", err)
+				lastMod, pending = mod, time.Time{}
+			}
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/valyala/fasthttp"
+)
+
+// wsWriteTimeout bounds each broadcast or ping write so one stuck client
+// can't hold up the others for long.
+const wsWriteTimeout = time.Second
+
+var (
+	totalWSConns       uint64
+	totalWSMessages    uint64
+	totalWSSendErrors  uint64
+	totalWSBroadcasts  uint64
+	totalWSFanoutNanos uint64
+	activeWSConns      int64
+	totalWSPings       uint64
+	totalWSPongs       uint64
+	totalWSPingErrors  uint64
+	totalWSNegotiated  uint64
+	totalWSRefused     uint64
+	// wsPingRTT records the time from sending a ping to reading its pong.
+	wsPingRTT            latencyHistogram
+	wsUpgradeHeaderNames = []string{
+		"Connection",
+		"Upgrade",
+		"Sec-Websocket-Version",
+		"Sec-Websocket-Key",
+		"Sec-Websocket-Protocol",
+		"Sec-Websocket-Extensions",
+	}
+)
+
+// normalizeWebSocketHeaders copies the handshake headers to the exact names
+// the upgrader peeks for, since header names aren't normalized by the
+// server.
+func normalizeWebSocketHeaders(h *fasthttp.RequestHeader) {
+	for _, name := range wsUpgradeHeaderNames {
+		if len(h.Peek(name)) == 0 {
+			if v := peekHeader(h, name); len(v) > 0 {
+				h.SetBytesV(name, append([]byte(nil), v...))
+			}
+		}
+	}
+}
+
+// wsHub is the registry of connected WebSocket clients.
+type wsHub struct {
+	upgrader websocket.FastHTTPUpgrader
+	// subprotocol, if set, must be among the client's requested ones
+	subprotocol string
+	mu          sync.Mutex
+	clients     map[*websocket.Conn]struct{}
+}
+
+func newWSHub(subprotocol string) *wsHub {
+	hub := &wsHub{
+		upgrader: websocket.FastHTTPUpgrader{
+			// this is a test server; any page may connect
+			CheckOrigin: func(*fasthttp.RequestCtx) bool { return true },
+		},
+		subprotocol: subprotocol,
+		clients:     make(map[*websocket.Conn]struct{}),
+	}
+	if subprotocol != "" {
+		hub.upgrader.Subprotocols = []string{subprotocol}
+	}
+	return hub
+}
+
+// requestsSubprotocol reports whether the handshake lists hub.subprotocol
+// in Sec-WebSocket-Protocol.
+func (hub *wsHub) requestsSubprotocol(h *fasthttp.RequestHeader) bool {
+	for _, p := range bytes.Split(peekHeader(h, "Sec-WebSocket-Protocol"), []byte(",")) {
+		if string(bytes.TrimSpace(p)) == hub.subprotocol {
+			return true
+		}
+	}
+	return false
+}
+
+// connected returns the currently registered clients.
+func (hub *wsHub) connected() []*websocket.Conn {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	clients := make([]*websocket.Conn, 0, len(hub.clients))
+	for c := range hub.clients {
+		clients = append(clients, c)
+	}
+	return clients
+}
+
+// handle upgrades the request and keeps the client registered until its
+// connection fails or it closes it. Incoming messages are discarded.
+func (hub *wsHub) handle(ctx *fasthttp.RequestCtx) {
+	if hub.subprotocol != "" {
+		if !hub.requestsSubprotocol(&ctx.Request.Header) {
+			atomic.AddUint64(&totalWSRefused, 1)
+			ctx.Error("subprotocol "+hub.subprotocol+" required", fasthttp.StatusBadRequest)
+			return
+		}
+		atomic.AddUint64(&totalWSNegotiated, 1)
+	}
+	normalizeWebSocketHeaders(&ctx.Request.Header)
+	hub.upgrader.Upgrade(ctx, func(c *websocket.Conn) {
+		atomic.AddUint64(&totalWSConns, 1)
+		atomic.AddInt64(&activeWSConns, 1)
+		c.SetPongHandler(func(data string) error {
+			// the pong echoes the ping's payload, its send time
+			if len(data) == 8 {
+				sent := int64(binary.BigEndian.Uint64([]byte(data)))
+				wsPingRTT.record(time.Duration(time.Now().UnixNano() - sent))
+				atomic.AddUint64(&totalWSPongs, 1)
+			}
+			return nil
+		})
+		hub.mu.Lock()
+		hub.clients[c] = struct{}{}
+		hub.mu.Unlock()
+		defer func() {
+			hub.mu.Lock()
+			delete(hub.clients, c)
+			hub.mu.Unlock()
+			atomic.AddInt64(&activeWSConns, -1)
+			c.Close()
+		}()
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+}
+
+// broadcast sends a numbered text message to every client each interval.
+// Clients whose write fails are closed, which ends their read loop in
+// handle and unregisters them.
+func (hub *wsHub) broadcast(interval time.Duration) {
+	var seq uint64
+	for now := range time.Tick(interval) {
+		seq++
+		msg := strconv.AppendUint([]byte("broadcast "), seq, 10)
+		msg = strconv.AppendInt(append(msg, ' '), now.UnixNano(), 10)
+
+		clients := hub.connected()
+		start := time.Now()
+		for _, c := range clients {
+			c.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := c.WriteMessage(websocket.TextMessage, msg); err != nil {
+				atomic.AddUint64(&totalWSSendErrors, 1)
+				c.Close()
+				continue
+			}
+			atomic.AddUint64(&totalWSMessages, 1)
+		}
+		atomic.AddUint64(&totalWSBroadcasts, 1)
+		atomic.AddUint64(&totalWSFanoutNanos, uint64(time.Since(start)))
+	}
+}
+
+// ping sends a ping carrying its send time to every client each interval.
+// Pongs are timed by the handler set up in handle, which only runs while
+// the client's messages are being read.
+func (hub *wsHub) ping(interval time.Duration) {
+	var payload [8]byte
+	for range time.Tick(interval) {
+		clients := hub.connected()
+		for _, c := range clients {
+			now := time.Now()
+			binary.BigEndian.PutUint64(payload[:], uint64(now.UnixNano()))
+			// WriteControl may run concurrently with other writes
+			if err := c.WriteControl(websocket.PingMessage, payload[:], now.Add(wsWriteTimeout)); err != nil {
+				atomic.AddUint64(&totalWSPingErrors, 1)
+				c.Close()
+				continue
+			}
+			atomic.AddUint64(&totalWSPings, 1)
+		}
+	}
+}